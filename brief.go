@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DEFAULT_WORD_COUNT = 1000
+)
+
+/*
+An editorial brief steers a single post, eg:
+
+	title: "5 Quick Low Carb Breakfasts"
+	keywords: [low carb breakfast, keto, meal prep]
+	audience: busy professionals new to keto
+	tone: friendly and practical
+	sections: [Why breakfast matters, The recipes, Meal prep tips]
+	links: [https://example.com/keto-guide]
+	wordCount: 1200
+	references: [https://example.com/some-article]
+*/
+type Brief struct {
+	Title      string   `yaml:"title"`
+	Keywords   []string `yaml:"keywords"`
+	Audience   string   `yaml:"audience"`
+	Tone       string   `yaml:"tone"`
+	Sections   []string `yaml:"sections"`   // required '##' sections, in order
+	Links      []string `yaml:"links"`      // urls that must appear in the article
+	WordCount  int      `yaml:"wordCount"`  // defaults to DEFAULT_WORD_COUNT
+	References []string `yaml:"references"` // urls scraped and summarized as article context
+}
+
+func LoadBrief(filename string) (*Brief, error) {
+	util.Info("Loading brief '%s'...", filename)
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	brief := &Brief{}
+	if err := yaml.Unmarshal(data, brief); err != nil {
+		return nil, fmt.Errorf("Failed to parse brief '%s': %v", filename, err)
+	}
+
+	if brief.WordCount <= 0 {
+		brief.WordCount = DEFAULT_WORD_COUNT
+	}
+
+	return brief, nil
+}
+
+// context used to generate a title if the brief doesn't specify one
+func (b *Brief) titleCtx() string {
+	ctx := ""
+	if len(b.Keywords) > 0 {
+		ctx += fmt.Sprintf("Target keywords: %s\n", strings.Join(b.Keywords, ", "))
+	}
+	if b.Audience != "" {
+		ctx += fmt.Sprintf("Target audience: %s\n", b.Audience)
+	}
+
+	return ctx
+}
+
+// constraints appended to the article prompt
+func (b *Brief) articleCtx() string {
+	ctx := b.titleCtx()
+	if b.Tone != "" {
+		ctx += fmt.Sprintf("Tone: %s\n", b.Tone)
+	}
+	if len(b.Sections) > 0 {
+		ctx += "The article must include the following '##' sections, in this order:\n"
+		for _, section := range b.Sections {
+			ctx += fmt.Sprintf("- %s\n", section)
+		}
+	}
+	if len(b.Links) > 0 {
+		ctx += "The article must link to each of the following urls using markdown links:\n"
+		for _, link := range b.Links {
+			ctx += fmt.Sprintf("- %s\n", link)
+		}
+	}
+
+	return ctx
+}
+
+// scrapes and summarizes the reference urls
+func (b *Brief) scrapeReferences(ctx context.Context) (string, error) {
+	if len(b.References) == 0 {
+		return "", nil
+	}
+
+	util.Info("Summarizing references...")
+	summary, err := util.SummarizeArticles(ctx, b.References)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("A summary of the reference material for the article is given below:\n%s\n", summary), nil
+}
+
+// makes sure the generated markdown honors the brief. missing links are appended
+// as further reading, missing sections are only reported
+func (b *Brief) enforce(markdown string) string {
+	lower := strings.ToLower(markdown)
+	for _, section := range b.Sections {
+		if !strings.Contains(lower, "## "+strings.ToLower(section)) {
+			util.Warning("Required section '%s' is missing from the article", section)
+		}
+	}
+
+	var missing []string
+	for _, link := range b.Links {
+		if !strings.Contains(markdown, link) {
+			missing = append(missing, link)
+		}
+	}
+
+	if len(missing) > 0 {
+		markdown += "\n\n## Further Reading\n\n"
+		for _, link := range missing {
+			markdown += fmt.Sprintf("- <%s>\n", link)
+		}
+	}
+
+	return markdown
+}
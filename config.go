@@ -1,29 +1,241 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/thumbnail"
 	"git.openpunk.com/CPunch/copywriter/util"
 	"github.com/go-ini/ini"
 )
 
+/*
+Options are resolved with the following precedence, lowest to highest:
+ 1. the defaults in NewConfig
+ 2. the top-level options in the config file
+ 3. the selected site profile in the config file
+ 4. environment variables (the 'env' tag of each option)
+ 5. command line flags that were explicitly passed (see CONFIG_FLAGS)
+*/
 type ConfigData struct {
-	TrendingCategory string `ini:"trend"`
-	CustomPrompt     string `ini:"custom"`
-	ImageStylePrompt string `ini:"image"`
-	TopicType        string `ini:"topicType"` // can be "trends" or "news"
+	TrendingCategory string `ini:"trend" env:"COPYWRITER_TREND"`
+	CustomPrompt     string `ini:"custom" env:"COPYWRITER_CUSTOM"`
+	ImageStylePrompt string `ini:"image" env:"COPYWRITER_IMAGE"`
+	TopicType        string `ini:"topicType" env:"COPYWRITER_TOPIC_TYPE"` // can be "trends", "news" or "queue"
+	QueueFile        string `ini:"queue" env:"COPYWRITER_QUEUE"`          // keyword/brief list used by the "queue" topic type
+	Locale           string `ini:"locale" env:"COPYWRITER_LOCALE"`        // eg. "en-US", used for google trends and available to prompt templates
+	PromptDir        string `ini:"prompts" env:"COPYWRITER_PROMPTS"`      // directory of prompt template overrides
+	OutDir           string `ini:"out" env:"COPYWRITER_OUT"`              // directory posts are written to
+	Author           string `ini:"author" env:"COPYWRITER_AUTHOR"`
+	ImageProvider    string `ini:"imageProvider" env:"COPYWRITER_IMAGE_PROVIDER"`         // can be "auto", "replicate", "scraper" or "library"
+	LibraryDir       string `ini:"library" env:"COPYWRITER_LIBRARY"`                      // directory of images used by the "library" provider
+	LibraryReuseDays int    `ini:"libraryReuseDays" env:"COPYWRITER_LIBRARY_REUSE_DAYS"`  // library images aren't reused for this many days
+	LibraryEmbed     bool   `ini:"libraryEmbeddings" env:"COPYWRITER_LIBRARY_EMBEDDINGS"` // match library images by embedding similarity rather than keywords
+	Captioner        string `ini:"captioner" env:"COPYWRITER_CAPTIONER"`                  // replicate model describing library images which have no sidecar description
+	Draft            bool   `ini:"draft" env:"COPYWRITER_DRAFT"`                          // posts are written as drafts until published
+	ImageWorkers     int    `ini:"imageWorkers" env:"COPYWRITER_IMAGE_WORKERS"`           // how many images are generated at once
+	ReplicateRate    int    `ini:"replicateRate" env:"COPYWRITER_REPLICATE_RATE"`         // max replicate predictions started per minute, 0 for no limit
+	WebhookURL       string `ini:"webhookURL" env:"COPYWRITER_WEBHOOK_URL"`               // public url replicate calls when a prediction completes, polling is used if empty
+	WebhookListen    string `ini:"webhookListen" env:"COPYWRITER_WEBHOOK_LISTEN"`         // address the webhook receiver listens on
+	ScraperRate      int    `ini:"scraperRate" env:"COPYWRITER_SCRAPER_RATE"`             // max image searches started per minute, 0 for no limit
+	LicensedImages   bool   `ini:"licensedImages" env:"COPYWRITER_LICENSED_IMAGES"`       // only use scraped images whose license is known to allow reuse
+	FallbackImage    string `ini:"fallbackImage" env:"COPYWRITER_FALLBACK_IMAGE"`         // url of an image used when the scraper finds nothing, finding the image fails if empty
+	DedupeImages     bool   `ini:"dedupeImages" env:"COPYWRITER_DEDUPE_IMAGES"`           // replace images which look like another one in the post or a recent post
+	DedupeDistance   int    `ini:"dedupeDistance" env:"COPYWRITER_DEDUPE_DISTANCE"`       // images whose perceptual hashes differ by at most this many bits are duplicates, 0 for exact copies only
+	DedupeDays       int    `ini:"dedupeDays" env:"COPYWRITER_DEDUPE_DAYS"`               // images are compared against the ones saved this many days back, besides the post's own
+	StagingDir       string `ini:"staging" env:"COPYWRITER_STAGING"`                      // if set, drafts are written here and moved to 'out' when published
+	Takeaways        bool   `ini:"takeaways" env:"COPYWRITER_TAKEAWAYS"`                  // add a key takeaways list after the introduction
+	FAQ              bool   `ini:"faq" env:"COPYWRITER_FAQ"`                              // add an FAQ section to the end of posts
+	TOC              bool   `ini:"toc" env:"COPYWRITER_TOC"`                              // set 'toc: true' in the front matter
+	StructuredData   string `ini:"structuredData" env:"COPYWRITER_STRUCTURED_DATA"`       // JSON-LD output, can be "off", "frontmatter" or "file"
+	SchemaType       string `ini:"schemaType" env:"COPYWRITER_SCHEMA_TYPE"`               // schema.org type of posts, eg. "BlogPosting"
+	BaseURL          string `ini:"baseURL" env:"COPYWRITER_BASE_URL"`                     // url the posts are served under, eg. "https://example.com/posts". structured data has no image without it
+	ThumbnailAspect  string `ini:"thumbnailAspect" env:"COPYWRITER_THUMBNAIL_ASPECT"`     // eg. "16:9", thumbnails are cropped to it. empty to keep them as they are
+	ThumbnailCrop    string `ini:"thumbnailCrop" env:"COPYWRITER_THUMBNAIL_CROP"`         // can be "center" or "entropy"
+	ThumbnailWidth   int    `ini:"thumbnailWidth" env:"COPYWRITER_THUMBNAIL_WIDTH"`       // thumbnails are scaled (or upscaled) to this width, 0 to keep their size
+	Upscaler         string `ini:"upscaler" env:"COPYWRITER_UPSCALER"`                    // replicate model ("owner/name" or "owner/name:version") for thumbnails narrower than thumbnailWidth
+	SocialCard       bool   `ini:"socialCard" env:"COPYWRITER_SOCIAL_CARD"`               // also write a 1200x630 variant of the thumbnail for og/twitter
+
+	Site        string            `ini:"-"` // name of the selected site profile, if any
+	FrontMatter map[string]string `ini:"-"` // extra front matter, values are emitted as-is
+
+	file            *ini.File         // the loaded config file, used to select site profiles
+	base            *ConfigData       // the config before a site profile was applied
+	flags           map[string]string // explicitly passed command line flags
+	sources         map[string]string // where each option's value came from
+	postDirOverride string            // set by 'write -o', posts are written there instead of 'staging' or 'out'
+	errors          []error           // problems found while loading this config's section, see Validate
 }
 
 const (
 	DEFAULT_TRENDING_CATEGORY = "all"
+	DEFAULT_LOCALE            = "en-US"
 	TOPIC_TYPE_TRENDS         = "trends"
 	TOPIC_TYPE_NEWS           = "news"
+	TOPIC_TYPE_QUEUE          = "queue"
+	DEFAULT_OUT_DIR           = "."
+	DEFAULT_AUTHOR            = "Mason Coleman"
+
+	IMAGE_PROVIDER_AUTO      = "auto" // replicate if REPLICATE_API_KEY is set, otherwise the scraper
+	IMAGE_PROVIDER_REPLICATE = "replicate"
+	IMAGE_PROVIDER_SCRAPER   = "scraper"
+	IMAGE_PROVIDER_LIBRARY   = "library" // a local directory of images, see imagelibrary
+	DEFAULT_IMAGE_WORKERS    = 3
+	DEFAULT_REPLICATE_RATE   = 60
+	DEFAULT_SCRAPER_RATE     = 20
+	DEFAULT_WEBHOOK_LISTEN   = ":8089"
+	DEFAULT_LIBRARY_REUSE    = 30
+	DEFAULT_DEDUPE_DISTANCE  = 10
+	DEFAULT_DEDUPE_DAYS      = 90
+
+	STRUCTURED_DATA_OFF         = "off"
+	STRUCTURED_DATA_FRONTMATTER = "frontmatter" // written to the 'jsonld' front matter param
+	STRUCTURED_DATA_FILE        = "file"        // written to schema.json in the page bundle
+	DEFAULT_SCHEMA_TYPE         = "BlogPosting"
+
+	DEFAULT_THUMBNAIL_CROP = thumbnail.CROP_ENTROPY
+
+	/*
+		site profiles are sections named 'site.<name>', and extra front matter is read
+		from the 'frontmatter' section and each site's 'site.<name>.frontmatter' section
+	*/
+	SITE_SECTION_PREFIX = "site."
+	FRONTMATTER_SECTION = "frontmatter"
+
+	SOURCE_DEFAULT = "default"
 )
 
-func NewConfig(TrendingCategory, CustomPrompt, ImageStylePrompt, TopicType string) *ConfigData {
-	return &ConfigData{
-		TrendingCategory: TrendingCategory,
-		CustomPrompt:     CustomPrompt,
-		ImageStylePrompt: ImageStylePrompt,
-		TopicType:        TopicType,
+var (
+	// top-level command line flags which map to an option
+	CONFIG_FLAGS = map[string]string{
+		"trend":       "trend",
+		"custom":      "custom",
+		"image":       "image",
+		"trend-topic": "topicType",
+		"queue":       "queue",
+	}
+)
+
+type configField struct {
+	Key   string // ini key
+	Env   string
+	Index int
+}
+
+// returns every option that can be set by the config file, environment or flags
+func configFields() []configField {
+	var fields []configField
+	typ := reflect.TypeOf(ConfigData{})
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		key := field.Tag.Get("ini")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		fields = append(fields, configField{Key: key, Env: field.Tag.Get("env"), Index: i})
+	}
+
+	return fields
+}
+
+func findConfigField(key string) (configField, bool) {
+	for _, field := range configFields() {
+		if field.Key == key {
+			return field, true
+		}
+	}
+
+	return configField{}, false
+}
+
+// flags are the explicitly passed command line flags, by flag name
+func NewConfig(flags map[string]string) *ConfigData {
+	config := &ConfigData{
+		TrendingCategory: DEFAULT_TRENDING_CATEGORY,
+		TopicType:        TOPIC_TYPE_TRENDS,
+		Locale:           DEFAULT_LOCALE,
+		OutDir:           DEFAULT_OUT_DIR,
+		Author:           DEFAULT_AUTHOR,
+		ImageProvider:    IMAGE_PROVIDER_AUTO,
+		ImageWorkers:     DEFAULT_IMAGE_WORKERS,
+		ReplicateRate:    DEFAULT_REPLICATE_RATE,
+		ScraperRate:      DEFAULT_SCRAPER_RATE,
+		WebhookListen:    DEFAULT_WEBHOOK_LISTEN,
+		LibraryReuseDays: DEFAULT_LIBRARY_REUSE,
+		DedupeImages:     true,
+		DedupeDistance:   DEFAULT_DEDUPE_DISTANCE,
+		DedupeDays:       DEFAULT_DEDUPE_DAYS,
+		Draft:            true,
+		StructuredData:   STRUCTURED_DATA_OFF,
+		SchemaType:       DEFAULT_SCHEMA_TYPE,
+		ThumbnailCrop:    DEFAULT_THUMBNAIL_CROP,
+		FrontMatter:      make(map[string]string),
+		flags:            flags,
+		sources:          make(map[string]string),
+	}
+
+	config.applyOverrides()
+	return config
+}
+
+// sets an option by its ini key, recording where the value came from
+func (config *ConfigData) set(key, value, source string) error {
+	field, ok := findConfigField(key)
+	if !ok {
+		return fmt.Errorf("Unknown option '%s'", key)
+	}
+
+	val := reflect.ValueOf(config).Elem().Field(field.Index)
+	switch val.Kind() {
+	case reflect.String:
+		val.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("Option '%s' expects a boolean, got '%s'", key, value)
+		}
+		val.SetBool(b)
+	case reflect.Int:
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Option '%s' expects an integer, got '%s'", key, value)
+		}
+		val.SetInt(int64(i))
+	default:
+		return fmt.Errorf("Option '%s' has an unsupported type", key)
+	}
+
+	config.sources[key] = source
+	return nil
+}
+
+// reapplies the environment and explicit flags, so they always win over the config file
+func (config *ConfigData) applyOverrides() {
+	for _, field := range configFields() {
+		if value, ok := os.LookupEnv(field.Env); ok {
+			if err := config.set(field.Key, value, "env "+field.Env); err != nil {
+				util.Fail("%v", err)
+			}
+		}
+	}
+
+	for name, value := range config.flags {
+		if err := config.set(CONFIG_FLAGS[name], value, "flag -"+name); err != nil {
+			util.Fail("%v", err)
+		}
+	}
+}
+
+func (config *ConfigData) loadSection(section *ini.Section, source string) {
+	for _, key := range section.Keys() {
+		if err := config.set(key.Name(), key.Value(), source); err != nil {
+			config.errors = append(config.errors, fmt.Errorf("[%s] %v", section.Name(), err))
+		}
 	}
 }
 
@@ -31,17 +243,98 @@ func (config *ConfigData) LoadConfig(filename string) {
 	util.Info("Loading config file '%s'...", filename)
 	cfg, err := ini.Load(filename)
 	if err != nil {
-		util.Warning("Failed to load config file: %v", err)
+		util.Fail("Failed to load config file: %v", err)
+	}
+
+	config.file = cfg
+	config.loadSection(cfg.Section(ini.DefaultSection), "ini "+filename)
+	config.loadFrontMatter(FRONTMATTER_SECTION)
+	config.applyOverrides()
+}
+
+func (config *ConfigData) loadFrontMatter(sectionName string) {
+	section, err := config.file.GetSection(sectionName)
+	if err != nil { // no front matter defaults
 		return
 	}
 
-	err = cfg.MapTo(&config)
+	for _, key := range section.Keys() {
+		config.FrontMatter[key.Name()] = key.Value()
+	}
+}
+
+// returns the names of the site profiles in the loaded config file
+func (config *ConfigData) Sites() []string {
+	if config.file == nil {
+		return nil
+	}
+
+	var sites []string
+	for _, name := range config.file.SectionStrings() {
+		site := strings.TrimPrefix(name, SITE_SECTION_PREFIX)
+		if site != name && site != "" && !strings.Contains(site, ".") {
+			sites = append(sites, site)
+		}
+	}
+
+	sort.Strings(sites)
+	return sites
+}
+
+// returns a copy of the config with the named site profile applied on top of the
+// top-level options
+func (config *ConfigData) ForSite(name string) (*ConfigData, error) {
+	base := config
+	if config.base != nil {
+		base = config.base
+	}
+
+	if base.file == nil {
+		return nil, fmt.Errorf("Site '%s' requested but no config file was loaded", name)
+	}
+
+	section, err := base.file.GetSection(SITE_SECTION_PREFIX + name)
 	if err != nil {
-		util.Fail("Failed to map config file: %v", err)
+		return nil, fmt.Errorf("Unknown site '%s'", name)
+	}
+
+	site := *base
+	site.base = base
+	site.Site = name
+	site.FrontMatter = make(map[string]string)
+	for key, value := range base.FrontMatter {
+		site.FrontMatter[key] = value
+	}
+	site.sources = make(map[string]string)
+	for key, source := range base.sources {
+		site.sources[key] = source
+	}
+
+	site.errors = nil
+	site.loadSection(section, fmt.Sprintf("ini [%s]", section.Name()))
+	site.loadFrontMatter(SITE_SECTION_PREFIX + name + "." + FRONTMATTER_SECTION)
+	site.applyOverrides()
+	return &site, nil
+}
+
+// returns where the value of the option came from
+func (config *ConfigData) Source(key string) string {
+	if source, ok := config.sources[key]; ok {
+		return source
+	}
+
+	return SOURCE_DEFAULT
+}
+
+// returns the directory new posts are written to
+func (config *ConfigData) postDir() string {
+	if config.postDirOverride != "" {
+		return config.postDirOverride
 	}
 
-	if config.TopicType != TOPIC_TYPE_NEWS && config.TopicType != TOPIC_TYPE_TRENDS {
-		util.Warning("Invalid topic type '%s', defaulting to '%s'", config.TopicType, TOPIC_TYPE_TRENDS)
-		config.TopicType = TOPIC_TYPE_TRENDS
+	if config.Draft && config.StagingDir != "" {
+		return config.StagingDir
 	}
+
+	return config.OutDir
 }
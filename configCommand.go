@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"text/tabwriter"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/google/subcommands"
+)
+
+type ConfigCommand struct{}
+
+func (*ConfigCommand) Name() string             { return "config" }
+func (*ConfigCommand) Synopsis() string         { return "Inspect the configuration" }
+func (*ConfigCommand) SetFlags(f *flag.FlagSet) {}
+
+func (*ConfigCommand) Usage() string {
+	return "config show|validate:\n\tshow: Print the effective configuration and where each value came from.\n\tvalidate: Check the configuration (and every site profile if no -site was selected) for problems.\n"
+}
+
+func (c *ConfigCommand) show(config *ConfigData) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if config.Site != "" {
+		fmt.Fprintf(w, "site\t%q\t(-site or COPYWRITER_SITE)\n", config.Site)
+	}
+
+	val := reflect.ValueOf(config).Elem()
+	for _, field := range configFields() {
+		fmt.Fprintf(w, "%s\t%q\t(%s)\n", field.Key, fmt.Sprint(val.Field(field.Index).Interface()), config.Source(field.Key))
+	}
+
+	// front matter defaults
+	keys := make([]string, 0, len(config.FrontMatter))
+	for key := range config.FrontMatter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s.%s\t%q\t(ini)\n", FRONTMATTER_SECTION, key, config.FrontMatter[key])
+	}
+	w.Flush()
+}
+
+func (c *ConfigCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	config := ctx.Value("conf").(*ConfigData)
+
+	switch f.Arg(0) {
+	case "show":
+		c.show(config)
+	case "validate":
+		configs := []*ConfigData{config}
+		if config.Site == "" {
+			for _, site := range config.Sites() {
+				siteConfig, err := config.ForSite(site)
+				if err != nil {
+					util.Fail("%v", err)
+				}
+				configs = append(configs, siteConfig)
+			}
+		}
+
+		requireValidConfigs(configs)
+		util.Success("Configuration is valid!")
+	default:
+		util.Warning("Unknown config command '%s'", f.Arg(0))
+		fmt.Print(c.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/imagescraper"
+)
+
+const (
+	CREDITS_HEADING  = "Image Credits"
+	FALLBACK_LICENSE = "license unknown" // what the fallback image is credited with, we can't tell its license
+)
+
+/*
+	Where each image came from, for images we didn't generate ourselves. Kept in the
+	front matter and rendered as the last section of the post:
+
+	credits:
+	  - file: "file_2.jpg"
+	    source: "https://commons.wikimedia.org/wiki/File:Oatmeal.jpg"
+	    author: "Jane Doe"
+	    license: "CC BY-SA 4.0"
+	    licenseURL: "https://creativecommons.org/licenses/by-sa/4.0"
+
+	## Image Credits
+
+	- Image 1: photo by Jane Doe on [commons.wikimedia.org](https://commons.wikimedia.org/wiki/File:Oatmeal.jpg), [CC BY-SA 4.0](https://creativecommons.org/licenses/by-sa/4.0)
+*/
+
+// attribution for an image in the post
+type Credit struct {
+	File       string `yaml:"file"`
+	Source     string `yaml:"source,omitempty"` // page the image was found on
+	Author     string `yaml:"author,omitempty"`
+	License    string `yaml:"license,omitempty"`
+	LicenseURL string `yaml:"licenseURL,omitempty"`
+}
+
+func creditFromScraper(file string, img *imagescraper.Image) *Credit {
+	if img.SourcePage == "" && img.Author == "" && img.License.Name == "" {
+		return nil
+	}
+
+	return &Credit{File: file, Source: img.SourcePage, Author: img.Author, License: img.License.Name, LicenseURL: img.License.URL}
+}
+
+// sets (or with nil, removes) the credit of an image. safe to call concurrently
+func (bw *BlogWriter) setCredit(file string, credit *Credit) {
+	bw.creditsMu.Lock()
+	defer bw.creditsMu.Unlock()
+
+	if credit == nil {
+		delete(bw.Credits, file)
+		return
+	}
+	bw.Credits[file] = credit
+}
+
+// the credits of the images still in the post, in order
+func (bw *BlogWriter) credits() []*Credit {
+	bw.creditsMu.Lock()
+	defer bw.creditsMu.Unlock()
+
+	var credits []*Credit
+	for _, img := range bw.images() {
+		if credit, ok := bw.Credits[img.File]; ok {
+			credits = append(credits, credit)
+		}
+	}
+	return credits
+}
+
+// front matter lines for the credits
+func creditsHeaders(credits []*Credit) string {
+	if len(credits) == 0 {
+		return ""
+	}
+
+	q := strconv.Quote
+	lines := "credits:\n"
+	for _, credit := range credits {
+		lines += fmt.Sprintf("  - file: %s\n", q(credit.File))
+		for _, field := range [][2]string{{"source", credit.Source}, {"author", credit.Author}, {"license", credit.License}, {"licenseURL", credit.LicenseURL}} {
+			if field[1] != "" {
+				lines += fmt.Sprintf("    %s: %s\n", field[0], q(field[1]))
+			}
+		}
+	}
+	return lines
+}
+
+// eg. 'photo by Jane Doe on [commons.wikimedia.org](...), [CC BY-SA 4.0](...)'
+func (c *Credit) markdown() string {
+	text := "photo"
+	if c.Author != "" {
+		text += " by " + c.Author
+	}
+
+	if u, err := url.Parse(c.Source); err == nil && u.Host != "" {
+		text += fmt.Sprintf(" on [%s](%s)", strings.TrimPrefix(u.Host, "www."), c.Source)
+	}
+
+	if c.License != "" && c.LicenseURL != "" {
+		text += fmt.Sprintf(", [%s](%s)", c.License, c.LicenseURL)
+	} else if c.License != "" {
+		text += ", " + c.License
+	}
+	return text
+}
+
+// rewrites the credits section at the end of the post from bw.Credits, removing it if
+// there's nothing to credit
+func (bw *BlogWriter) renderCredits() {
+	var sections []mdSection
+	for _, section := range splitSections(bw.Content) {
+		if section.Heading != CREDITS_HEADING {
+			sections = append(sections, section)
+		}
+	}
+	bw.Content = strings.TrimRight(joinSections(sections), "\n") + "\n"
+
+	credits := bw.credits()
+	if len(credits) == 0 {
+		return
+	}
+
+	text := fmt.Sprintf("\n## %s\n\n", CREDITS_HEADING)
+	for _, credit := range credits {
+		label := "Cover image"
+		for n, img := range findImages(bw.Content) {
+			if img.File == credit.File {
+				label = fmt.Sprintf("Image %d", n+1)
+			}
+		}
+		text += fmt.Sprintf("- %s: %s\n", label, credit.markdown())
+	}
+	bw.Content += text
+}
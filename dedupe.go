@@ -0,0 +1,195 @@
+package main
+
+import (
+	"path"
+	"sync"
+
+	"git.openpunk.com/CPunch/copywriter/imagehash"
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	MAX_DUPLICATE_RETRIES = 3 // times a duplicate image is replaced before giving up
+)
+
+var (
+	// opened image hash indexes by directory, shared by every post written to it
+	imageHashIndexes   = make(map[string]*imagehash.Index)
+	imageHashIndexesMu sync.Mutex
+)
+
+// opens the index of image hashes kept in the directory posts are written to, eg. the
+// output or staging directory
+func imageHashIndex(dir string) (*imagehash.Index, error) {
+	imageHashIndexesMu.Lock()
+	defer imageHashIndexesMu.Unlock()
+
+	dir = path.Clean(dir)
+	if idx, ok := imageHashIndexes[dir]; ok {
+		return idx, nil
+	}
+
+	idx, err := imagehash.Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	imageHashIndexes[dir] = idx
+	return idx, nil
+}
+
+// the index of the directory the post is in
+func (bw *BlogWriter) imageHashIndex() (*imagehash.Index, error) {
+	return imageHashIndex(path.Dir(bw.outDir))
+}
+
+// checks the perceptual hash of the saved image against the post's other images and
+// the ones used by other posts within dedupeDays, returning the closest near-duplicate.
+// posts written elsewhere (eg. staged) are checked against the published ones too. the
+// hash is kept either way, and recorded in the index once the post is saved
+func (bw *BlogWriter) checkDuplicate(fileName string) (*imagehash.Entry, error) {
+	if !bw.config.DedupeImages {
+		return nil, nil
+	}
+
+	idx, err := bw.imageHashIndex()
+	if err != nil {
+		util.Warning("Failed to load image index, skipping duplicate check: %v", err)
+		return nil, nil
+	}
+
+	hash, err := imagehash.HashFile(path.Join(bw.outDir, fileName))
+	if err != nil {
+		// eg. a format we can't decode, there's no telling if it's a duplicate
+		util.Warning("Failed to hash '%s', skipping duplicate check: %v", fileName, err)
+		return nil, nil
+	}
+
+	bw.imageHashesMu.Lock()
+	defer bw.imageHashesMu.Unlock()
+	bw.imageHashes[fileName] = hash
+
+	post := path.Base(bw.outDir)
+	for file, other := range bw.imageHashes {
+		if file != fileName && imagehash.Distance(hash, other) <= bw.config.DedupeDistance {
+			return &imagehash.Entry{Post: post, File: file}, nil
+		}
+	}
+
+	since := bw.started.AddDate(0, 0, -bw.config.DedupeDays)
+	if dup := idx.Find(post, fileName, hash, bw.config.DedupeDistance, since); dup != nil {
+		return dup, nil
+	}
+
+	if bw.config.OutDir == "" || path.Clean(bw.config.OutDir) == idx.Dir {
+		return nil, nil
+	}
+	live, err := imageHashIndex(bw.config.OutDir)
+	if err != nil {
+		util.Warning("Failed to load image index, skipping duplicate check: %v", err)
+		return nil, nil
+	}
+	return live.Find(post, fileName, hash, bw.config.DedupeDistance, since), nil
+}
+
+// replaces the hash of an image which changed after it was checked (eg. a cropped
+// thumbnail), so the index describes the image as it's saved
+func (bw *BlogWriter) rehashImage(fileName string) {
+	if !bw.config.DedupeImages {
+		return
+	}
+
+	hash, err := imagehash.HashFile(path.Join(bw.outDir, fileName))
+	if err != nil {
+		util.Warning("Failed to hash '%s': %v", fileName, err)
+		return
+	}
+
+	bw.imageHashesMu.Lock()
+	defer bw.imageHashesMu.Unlock()
+	bw.imageHashes[fileName] = hash
+}
+
+// records the hashes of the images saved since the post was last written, so posts which
+// fail or are discarded don't block similar images
+func (bw *BlogWriter) recordImageHashes() {
+	if !bw.config.DedupeImages || util.IsDryRun() {
+		return
+	}
+
+	bw.imageHashesMu.Lock()
+	defer bw.imageHashesMu.Unlock()
+
+	// images replaced since, eg. by regenerating a section, are gone
+	hashes := make(map[string]uint64)
+	for _, img := range bw.images() {
+		if hash, ok := bw.imageHashes[img.File]; ok {
+			hashes[img.File] = hash
+		}
+	}
+
+	idx, err := bw.imageHashIndex()
+	if err == nil {
+		err = idx.Record(path.Base(bw.outDir), hashes)
+	}
+	if err != nil {
+		util.Warning("Failed to update image index: %v", err)
+		return
+	}
+	bw.imageHashes = make(map[string]uint64)
+}
+
+// drops a removed image from the index
+func (bw *BlogWriter) forgetImage(fileName string) {
+	if !bw.config.DedupeImages {
+		return
+	}
+
+	bw.imageHashesMu.Lock()
+	delete(bw.imageHashes, fileName)
+	bw.imageHashesMu.Unlock()
+
+	idx, err := bw.imageHashIndex()
+	if err == nil {
+		err = idx.Remove(path.Base(bw.outDir), fileName)
+	}
+	if err != nil {
+		util.Warning("Failed to update image index: %v", err)
+	}
+}
+
+// keeps the index pointing at the post after its directory was renamed
+func (bw *BlogWriter) renameImageHashes(post, newPost string) {
+	if !bw.config.DedupeImages {
+		return
+	}
+
+	idx, err := bw.imageHashIndex()
+	if err == nil {
+		err = idx.Rename(post, newPost)
+	}
+	if err != nil {
+		util.Warning("Failed to update image index: %v", err)
+	}
+}
+
+// moves the post's hashes to the index of the directory it was moved to, eg. when it's
+// published out of the staging directory
+func moveImageHashes(config *ConfigData, post, from, to string) {
+	if !config.DedupeImages {
+		return
+	}
+
+	src, err := imageHashIndex(from)
+	if err != nil {
+		util.Warning("Failed to update image index: %v", err)
+		return
+	}
+	dst, err := imageHashIndex(to)
+	if err == nil {
+		err = src.MoveTo(post, dst)
+	}
+	if err != nil {
+		util.Warning("Failed to update image index: %v", err)
+	}
+}
@@ -0,0 +1,244 @@
+package imagehash
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"git.openpunk.com/CPunch/copywriter/thumbnail"
+)
+
+/*
+	Every image saved to a post is hashed with a difference hash (dHash): the image is
+	shrunk to 9x8 and turned grey, and each bit says whether a pixel is brighter than
+	the one to its right. Resizing, recompressing or slightly recoloring a picture
+	barely changes its hash, so the number of differing bits (the distance) tells how
+	alike two images are.
+
+	The hashes of every post's images are kept in an index file in the output
+	directory, so new images can be compared against the ones already on the site:
+
+		{"images": [{"post": "low-carb-breakfast-ideas", "file": "file_1.jpg", "hash": "f0e4c2d2c6c4a4e0", "saved": 1692544800}]}
+*/
+
+const (
+	INDEX_FILE_NAME = ".copywriter-images.json"
+
+	HASH_WIDTH  = 9
+	HASH_HEIGHT = 8
+)
+
+// 64 bit difference hash of the image
+func Hash(img image.Image) uint64 {
+	small := thumbnail.Resize(img, HASH_WIDTH, HASH_HEIGHT)
+
+	var hash uint64
+	for y := 0; y < HASH_HEIGHT; y++ {
+		for x := 0; x < HASH_WIDTH-1; x++ {
+			left := color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y
+			right := color.GrayModel.Convert(small.At(x+1, y)).(color.Gray).Y
+
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// the number of bits two hashes differ by, 0 for the same picture
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// hashes the image file
+func HashFile(path string) (uint64, error) {
+	img, err := thumbnail.Load(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return Hash(img), nil
+}
+
+type Entry struct {
+	Post  string `json:"post"` // the post's directory name
+	File  string `json:"file"`
+	Hash  string `json:"hash"`  // hex
+	Saved int64  `json:"saved"` // unix time
+}
+
+func (e *Entry) hash() uint64 {
+	hash, _ := strconv.ParseUint(e.Hash, 16, 64)
+	return hash
+}
+
+type Index struct {
+	Dir    string
+	Images []*Entry
+
+	mu sync.Mutex
+}
+
+// loads the index in dir, which is empty if there isn't one yet
+func Load(dir string) (*Index, error) {
+	idx := &Index{Dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, INDEX_FILE_NAME))
+	if os.IsNotExist(err) {
+		return idx, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var index struct {
+		Images []*Entry `json:"images"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("bad index '%s': %v", filepath.Join(dir, INDEX_FILE_NAME), err)
+	}
+
+	idx.Images = index.Images
+	return idx, nil
+}
+
+func (idx *Index) Save() error {
+	data, err := json.MarshalIndent(struct {
+		Images []*Entry `json:"images"`
+	}{idx.Images}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(idx.Dir, INDEX_FILE_NAME), data, 0644)
+}
+
+func (idx *Index) find(post, file string, hash uint64, maxDistance int, since time.Time) *Entry {
+	var closest *Entry
+	closestDistance := maxDistance + 1
+	for _, e := range idx.Images {
+		if e.Post == post && e.File == file {
+			continue // the image being replaced
+		}
+		if e.Post != post && e.Saved < since.Unix() {
+			continue
+		}
+
+		if d := Distance(hash, e.hash()); d < closestDistance {
+			closest, closestDistance = e, d
+		}
+	}
+	return closest
+}
+
+// the closest image within maxDistance of the hash, among the post's other images and
+// images saved since then. returns nil if there's none. safe to call concurrently
+func (idx *Index) Find(post, file string, hash uint64, maxDistance int, since time.Time) *Entry {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	return idx.find(post, file, hash, maxDistance, since)
+}
+
+// records the hashes of the post's images (by file), replacing what was recorded for
+// those files before. safe to call concurrently
+func (idx *Index) Record(post string, hashes map[string]uint64) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	now := time.Now().Unix()
+	for file, hash := range hashes {
+		idx.remove(post, file)
+		idx.Images = append(idx.Images, &Entry{Post: post, File: file, Hash: fmt.Sprintf("%016x", hash), Saved: now})
+	}
+	return idx.Save()
+}
+
+func (idx *Index) remove(post, file string) bool {
+	for i, e := range idx.Images {
+		if e.Post == post && e.File == file {
+			idx.Images = append(idx.Images[:i], idx.Images[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// forgets the post's image. safe to call concurrently
+func (idx *Index) Remove(post, file string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if !idx.remove(post, file) {
+		return nil
+	}
+	return idx.Save()
+}
+
+// moves the post's images over to its new directory name. safe to call concurrently
+func (idx *Index) Rename(post, newPost string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	renamed := false
+	for _, e := range idx.Images {
+		if e.Post == post {
+			e.Post = newPost
+			renamed = true
+		}
+	}
+
+	if !renamed {
+		return nil
+	}
+	return idx.Save()
+}
+
+// moves the post's images over to another index, keeping when they were saved. safe to
+// call concurrently
+func (idx *Index) MoveTo(post string, dst *Index) error {
+	if dst == idx {
+		return nil
+	}
+
+	idx.mu.Lock()
+	var moved, kept []*Entry
+	for _, e := range idx.Images {
+		if e.Post == post {
+			moved = append(moved, e)
+		} else {
+			kept = append(kept, e)
+		}
+	}
+	if len(moved) == 0 {
+		idx.mu.Unlock()
+		return nil
+	}
+
+	idx.Images = kept
+	err := idx.Save()
+	idx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	for _, e := range moved {
+		dst.remove(e.Post, e.File)
+		dst.Images = append(dst.Images, e)
+	}
+	return dst.Save()
+}
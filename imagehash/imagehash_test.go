@@ -0,0 +1,126 @@
+package imagehash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"git.openpunk.com/CPunch/copywriter/thumbnail"
+)
+
+// a diagonal gradient with a bright square in it
+func testImage(width, height int, brighten uint8) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8((x + y) * 200 / (width + height))
+			if x > width/4 && x < width/2 && y > height/3 && y < height*2/3 {
+				v = 230
+			}
+			img.Set(x, y, color.RGBA{v + brighten/10, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestHash(t *testing.T) {
+	original := Hash(testImage(640, 480, 0))
+
+	// resized and slightly recolored copies are the same picture
+	if d := Distance(original, Hash(thumbnail.Resize(testImage(640, 480, 0), 320, 240))); d > 4 {
+		t.Errorf("resized copy is %d bits off", d)
+	}
+	if d := Distance(original, Hash(testImage(640, 480, 200))); d > 4 {
+		t.Errorf("recolored copy is %d bits off", d)
+	}
+
+	// the mirror image isn't
+	mirrored := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	src := testImage(640, 480, 0)
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			mirrored.Set(639-x, y, src.At(x, y))
+		}
+	}
+	if d := Distance(original, Hash(mirrored)); d < 20 {
+		t.Errorf("mirrored image is only %d bits off", d)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir := t.TempDir()
+	idx, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	idx.Images = []*Entry{
+		{Post: "old-post", File: "file_1.jpg", Hash: "00000000000000ff", Saved: now.AddDate(0, 0, -100).Unix()},
+		{Post: "recent-post", File: "file_1.jpg", Hash: "ff00000000000000", Saved: now.AddDate(0, 0, -1).Unix()},
+	}
+	since := now.AddDate(0, 0, -90)
+
+	// too old to count
+	if dup := idx.Find("new-post", "file_1.jpg", 0xff, 4, since); dup != nil {
+		t.Fatalf("got %v", dup)
+	}
+
+	// used by a recent post
+	if dup := idx.Find("new-post", "file_1.jpg", 0xff00000000000001, 4, since); dup == nil || dup.Post != "recent-post" {
+		t.Fatalf("expected the recent post's image, got %v", dup)
+	}
+
+	// a distance of 0 only catches exact copies
+	if dup := idx.Find("new-post", "file_1.jpg", 0xff00000000000001, 0, since); dup != nil {
+		t.Fatalf("got %v", dup)
+	}
+	if dup := idx.Find("new-post", "file_1.jpg", 0xff00000000000000, 0, since); dup == nil {
+		t.Fatal("expected the exact copy")
+	}
+
+	if err := idx.Record("new-post", map[string]uint64{"file_1.jpg": 0x1ff}); err != nil {
+		t.Fatal(err)
+	}
+
+	// old images of the same post still count, but not the image being replaced
+	if dup := idx.Find("old-post", "file_2.jpg", 0xfe, 4, since); dup == nil || dup.Post != "old-post" {
+		t.Fatalf("expected the post's own image, got %v", dup)
+	}
+	if dup := idx.Find("new-post", "file_1.jpg", 0x1ff, 4, since); dup != nil {
+		t.Fatalf("got %v", dup)
+	}
+
+	if err := idx.Rename("new-post", "renamed-post"); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Remove("old-post", "file_1.jpg"); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Images) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(loaded.Images))
+	}
+	if e := loaded.Images[1]; e.Post != "renamed-post" || e.File != "file_1.jpg" || e.hash() != 0x1ff {
+		t.Fatalf("got %+v", e)
+	}
+	// eg. a post published out of the staging directory
+	published, err := Load(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.MoveTo("renamed-post", published); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Images) != 1 || len(published.Images) != 1 || published.Images[0].Post != "renamed-post" {
+		t.Fatalf("moved to %v, left %v", published.Images, loaded.Images)
+	}
+	if reloaded, err := Load(published.Dir); err != nil || len(reloaded.Images) != 1 {
+		t.Fatalf("move wasn't saved: %v", err)
+	}
+}
@@ -0,0 +1,468 @@
+package imagelibrary
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"gopkg.in/yaml.v3"
+)
+
+/*
+	A library is a directory (and its subdirectories) of images we're allowed to use,
+	eg. licensed stock photos. Each image can have a sidecar file next to it, named
+	after the image, describing it:
+
+		beach/sunset-01.jpg
+		beach/sunset-01.yaml:
+			description: "A couple walking along the beach at sunset"
+			tags: [beach, sunset, couple, summer]
+			author: "Jane Doe" # optional attribution, credited in posts
+			license: "CC BY 4.0"
+			licenseURL: "https://creativecommons.org/licenses/by/4.0"
+			source: "https://example.com/photos/sunset-01"
+
+		beach/sunset-02.jpg
+		beach/sunset-02.txt:
+			Palm trees silhouetted against an orange sky
+
+	What we know about every image is kept in an index file at the root of the
+	library, so only new or changed images are described (and embedded) again.
+	The index also records when each image was last used, so posts don't keep
+	reusing the same pictures.
+*/
+
+const (
+	INDEX_FILE_NAME = ".copywriter-index.json"
+
+	// matches scoring below these are ignored. unrelated text is rarely less than 0.7
+	// similar with ada-002 embeddings
+	MIN_KEYWORD_SCORE = 0.3
+	MIN_SIMILARITY    = 0.85
+)
+
+var (
+	IMAGE_EXTENSIONS   = []string{".jpg", ".jpeg", ".png", ".gif"}
+	SIDECAR_EXTENSIONS = []string{".yaml", ".yml", ".txt"}
+
+	// words which say nothing about what's in an image
+	STOP_WORDS = map[string]bool{
+		"the": true, "and": true, "with": true, "for": true, "from": true, "into": true,
+		"of": true, "in": true, "on": true, "at": true, "to": true, "an": true, "a": true,
+		"image": true, "photo": true, "picture": true, "shot": true, "img": true, "dsc": true,
+		"jpg": true, "jpeg": true, "png": true,
+	}
+)
+
+type Image struct {
+	File        string    `json:"file"` // relative to the library directory
+	ModTime     int64     `json:"modTime"`
+	Description string    `json:"description,omitempty"`
+	Keywords    []string  `json:"keywords"`
+	Embedding   []float32 `json:"embedding,omitempty"`
+	LastUsed    int64     `json:"lastUsed,omitempty"` // unix time, 0 if never used
+
+	// attribution from the sidecar, if any
+	Author     string `json:"author,omitempty"`
+	License    string `json:"license,omitempty"`
+	LicenseURL string `json:"licenseURL,omitempty"`
+	Source     string `json:"source,omitempty"`
+}
+
+type Library struct {
+	Dir    string
+	Images []*Image
+
+	reserved map[*Image]int64 // picked images which weren't kept yet, and when they were used before
+	mu       sync.Mutex
+}
+
+type Match struct {
+	Image *Image
+	Score float64 // 0-1, higher is better
+}
+
+// optional hooks used while indexing
+type Options struct {
+	Describe func(path string) (string, error)    // describes images without a description, eg. with a captioning model
+	Embed    func(text string) ([]float32, error) // embeds the description and keywords, for similarity search
+}
+
+type sidecar struct {
+	Description string   `yaml:"description"`
+	Tags        []string `yaml:"tags"`
+	Author      string   `yaml:"author"`
+	License     string   `yaml:"license"`
+	LicenseURL  string   `yaml:"licenseURL"`
+	Source      string   `yaml:"source"`
+}
+
+func isImage(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, imageExt := range IMAGE_EXTENSIONS {
+		if ext == imageExt {
+			return true
+		}
+	}
+	return false
+}
+
+// splits text into lowercase keywords, dropping stop words, numbers and plurals
+func Keywords(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+
+	seen := make(map[string]bool)
+	var keywords []string
+	for _, word := range words {
+		if len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") {
+			word = strings.TrimSuffix(word, "s")
+		}
+
+		if len(word) < 2 || STOP_WORDS[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		keywords = append(keywords, word)
+	}
+
+	return keywords
+}
+
+// reads the image's sidecar file, if it has one
+func readSidecar(imagePath string) (*sidecar, error) {
+	base := strings.TrimSuffix(imagePath, filepath.Ext(imagePath))
+	for _, ext := range []string{".yaml", ".yml"} {
+		data, err := os.ReadFile(base + ext)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var meta sidecar
+		if err := yaml.Unmarshal(data, &meta); err != nil {
+			return nil, fmt.Errorf("bad sidecar '%s': %v", base+ext, err)
+		}
+		return &meta, nil
+	}
+
+	data, err := os.ReadFile(base + ".txt")
+	if os.IsNotExist(err) {
+		return &sidecar{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &sidecar{Description: strings.TrimSpace(string(data))}, nil
+}
+
+// unix time of the newest sidecar of the image, 0 if it has none
+func sidecarModTime(imagePath string) int64 {
+	base := strings.TrimSuffix(imagePath, filepath.Ext(imagePath))
+
+	var newest int64
+	for _, ext := range SIDECAR_EXTENSIONS {
+		if info, err := os.Stat(base + ext); err == nil && info.ModTime().Unix() > newest {
+			newest = info.ModTime().Unix()
+		}
+	}
+	return newest
+}
+
+// loads the library's index without looking for new images, see Open
+func Load(dir string) (*Library, error) {
+	l := &Library{Dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, INDEX_FILE_NAME))
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var index struct {
+		Images []*Image `json:"images"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("bad index '%s': %v", filepath.Join(dir, INDEX_FILE_NAME), err)
+	}
+
+	l.Images = index.Images
+	return l, nil
+}
+
+// loads the library and indexes any images which are new or changed since the last
+// time, dropping images which were removed. the index is saved afterwards
+func Open(dir string, opts Options) (*Library, error) {
+	l, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]*Image)
+	for _, img := range l.Images {
+		known[img.File] = img
+	}
+
+	var images []*Image
+	err = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !isImage(d.Name()) {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		file, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		file = filepath.ToSlash(file)
+
+		// editing the sidecar counts as changing the image
+		modTime := info.ModTime().Unix()
+		if t := sidecarModTime(p); t > modTime {
+			modTime = t
+		}
+
+		if img, ok := known[file]; ok && img.ModTime == modTime && !incomplete(img, opts) {
+			images = append(images, img)
+			return nil
+		}
+
+		img, err := indexImage(p, file, opts)
+		if err != nil {
+			util.Warning("Skipping '%s': %v", p, err)
+			return nil
+		}
+		img.ModTime = modTime
+
+		// a changed image is still the same picture as far as reuse goes
+		if old, ok := known[file]; ok {
+			img.LastUsed = old.LastUsed
+		}
+		images = append(images, img)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l.Images = images
+	return l, l.Save()
+}
+
+// true if the image is missing something the options could add, eg. because
+// embeddings were switched on after it was indexed
+func incomplete(img *Image, opts Options) bool {
+	return (img.Description == "" && opts.Describe != nil) || (len(img.Embedding) == 0 && opts.Embed != nil)
+}
+
+func indexImage(p, file string, opts Options) (*Image, error) {
+	meta, err := readSidecar(p)
+	if err != nil {
+		return nil, err
+	}
+
+	img := &Image{
+		File:        file,
+		Description: meta.Description,
+		Author:      meta.Author,
+		License:     meta.License,
+		LicenseURL:  meta.LicenseURL,
+		Source:      meta.Source,
+	}
+	if img.Description == "" && opts.Describe != nil {
+		util.Info("Describing '%s'...", file)
+		if img.Description, err = opts.Describe(p); err != nil {
+			return nil, fmt.Errorf("failed to describe image: %v", err)
+		}
+	}
+
+	// the file name and its directories usually say something too, eg. 'beach/sunset-01.jpg'
+	text := strings.Join(append(meta.Tags, img.Description, strings.TrimSuffix(file, filepath.Ext(file))), " ")
+	img.Keywords = Keywords(text)
+
+	if opts.Embed != nil {
+		if img.Embedding, err = opts.Embed(strings.Join(append([]string{img.Description}, img.Keywords...), " ")); err != nil {
+			return nil, fmt.Errorf("failed to embed image description: %v", err)
+		}
+	}
+
+	return img, nil
+}
+
+// writes the index. images which are only reserved are saved as they were before
+func (l *Library) Save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.save()
+}
+
+func (l *Library) save() error {
+	images := make([]*Image, len(l.Images))
+	for i, img := range l.Images {
+		if lastUsed, ok := l.reserved[img]; ok {
+			unused := *img
+			unused.LastUsed = lastUsed
+			img = &unused
+		}
+		images[i] = img
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Images []*Image `json:"images"`
+	}{images}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(l.Dir, INDEX_FILE_NAME), data, 0644)
+}
+
+// full path of an image in the library
+func (l *Library) Path(img *Image) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(img.File))
+}
+
+func cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
+
+// how well the image matches the query. queries with an embedding are compared by
+// similarity, images without one don't match them. otherwise it's the share of query
+// keywords the image has. the two scales don't compare, so they're never mixed
+func score(img *Image, keywords []string, embedding []float32) float64 {
+	if len(embedding) > 0 {
+		if similarity := cosine(img.Embedding, embedding); similarity >= MIN_SIMILARITY {
+			return similarity
+		}
+		return 0
+	}
+
+	if len(keywords) == 0 {
+		return 0
+	}
+
+	has := make(map[string]bool)
+	for _, keyword := range img.Keywords {
+		has[keyword] = true
+	}
+
+	matched := 0
+	for _, keyword := range keywords {
+		if has[keyword] {
+			matched++
+		}
+	}
+
+	if s := float64(matched) / float64(len(keywords)); s >= MIN_KEYWORD_SCORE {
+		return s
+	}
+	return 0
+}
+
+// returns the images matching the query, best first. embedding may be nil
+func (l *Library) Search(query string, embedding []float32) []Match {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.search(query, embedding, time.Time{})
+}
+
+// matches which weren't used since then
+func (l *Library) search(query string, embedding []float32, since time.Time) []Match {
+	keywords := Keywords(query)
+
+	var matches []Match
+	for _, img := range l.Images {
+		if !since.IsZero() && img.LastUsed != 0 && img.LastUsed >= since.Unix() {
+			continue
+		}
+
+		if s := score(img, keywords, embedding); s > 0 {
+			matches = append(matches, Match{Image: img, Score: s})
+		}
+	}
+
+	// least recently used first among equally good matches, so the library gets rotated
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Image.LastUsed < matches[j].Image.LastUsed
+	})
+	return matches
+}
+
+// picks the best match for the query which wasn't used since then, and reserves it so
+// it isn't picked again. reservations are only saved by Keep, Release undoes them.
+// returns nil if nothing matches. safe to call concurrently
+func (l *Library) Pick(query string, embedding []float32, since time.Time) *Image {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	matches := l.search(query, embedding, since)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	img := matches[0].Image
+	if l.reserved == nil {
+		l.reserved = make(map[*Image]int64)
+	}
+	if _, ok := l.reserved[img]; !ok {
+		l.reserved[img] = img.LastUsed
+	}
+	img.LastUsed = time.Now().Unix()
+	return img
+}
+
+// saves the reserved images as used
+func (l *Library) Keep(imgs ...*Image) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, img := range imgs {
+		delete(l.reserved, img)
+	}
+	return l.save()
+}
+
+// undoes the reservation of images which ended up unused
+func (l *Library) Release(imgs ...*Image) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, img := range imgs {
+		if lastUsed, ok := l.reserved[img]; ok {
+			img.LastUsed = lastUsed
+			delete(l.reserved, img)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package imagelibrary
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, p, content string) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeywords(t *testing.T) {
+	got := Keywords("A photo of two Dogs playing on the beach_at-sunset, 4k")
+	want := []string{"two", "dog", "playing", "beach", "sunset"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "beach", "sunset-01.jpg"), "jpg")
+	writeFile(t, filepath.Join(dir, "beach", "sunset-01.yaml"), "description: A couple walking along the shore\ntags: [summer, couple]\nauthor: Jane Doe\nlicense: CC0\n")
+	writeFile(t, filepath.Join(dir, "beach", "sunset-02.jpg"), "jpg")
+	writeFile(t, filepath.Join(dir, "office.png"), "png")
+	writeFile(t, filepath.Join(dir, "notes.md"), "not an image")
+
+	described := 0
+	opts := Options{
+		Describe: func(p string) (string, error) {
+			described++
+			return "People working at laptops", nil
+		},
+	}
+
+	l, err := Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Images) != 3 || described != 2 {
+		t.Fatalf("indexed %d images and described %d", len(l.Images), described)
+	}
+
+	matches := l.Search("a couple on the beach in summer", nil)
+	if len(matches) == 0 || matches[0].Image.File != "beach/sunset-01.jpg" {
+		t.Fatalf("unexpected matches %v", matches)
+	}
+	if img := matches[0].Image; img.Author != "Jane Doe" || img.License != "CC0" {
+		t.Fatalf("lost the sidecar attribution, got '%s' and '%s'", img.Author, img.License)
+	}
+	if len(l.Search("mountain climbing", nil)) != 0 {
+		t.Fatal("unrelated query matched")
+	}
+
+	// picked images are skipped until they're old enough
+	start := time.Now()
+	first := l.Pick("beach sunset", nil, start.AddDate(0, 0, -30))
+	if first == nil {
+		t.Fatal("nothing picked")
+	}
+	second := l.Pick("beach sunset", nil, start.AddDate(0, 0, -30))
+	if second == nil || second.File == first.File {
+		t.Fatalf("picked %v after %v", second, first)
+	}
+	if third := l.Pick("beach sunset", nil, start.AddDate(0, 0, -30)); third != nil {
+		t.Fatalf("reused %s", third.File)
+	}
+
+	// only kept images are saved as used, released ones can be picked again
+	if err := l.Keep(first); err != nil {
+		t.Fatal(err)
+	}
+	l.Release(second)
+	if again := l.Pick("beach sunset", nil, start.AddDate(0, 0, -30)); again != second {
+		t.Fatalf("picked %v after releasing %v", again, second)
+	}
+
+	// the index is kept, so nothing is described again
+	l, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if described != 2 {
+		t.Fatalf("described %d images again", described-2)
+	}
+	for _, img := range l.Images {
+		if img.File == first.File && img.LastUsed == 0 {
+			t.Fatal("lost when the image was used")
+		} else if img.File == second.File && img.LastUsed != 0 {
+			t.Fatal("saved a reservation which wasn't kept")
+		}
+	}
+
+	// editing a sidecar reindexes its image
+	sidecar := filepath.Join(dir, "beach", "sunset-02.txt")
+	writeFile(t, sidecar, "Palm trees against an orange sky")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(sidecar, later, later)
+	if l, err = Open(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	if matches := l.Search("palm trees", nil); len(matches) != 1 || matches[0].Image.File != "beach/sunset-02.jpg" {
+		t.Fatalf("sidecar change wasn't picked up, got %v", matches)
+	}
+
+	// switching embeddings on embeds the images indexed without them
+	opts.Embed = func(text string) ([]float32, error) { return []float32{1, 0}, nil }
+	if l, err = Open(dir, opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range l.Images {
+		if len(img.Embedding) == 0 {
+			t.Fatalf("'%s' wasn't embedded", img.File)
+		}
+	}
+}
+
+func TestEmbeddings(t *testing.T) {
+	img := &Image{Keywords: []string{"beach"}, Embedding: []float32{1, 0, 0}}
+	if s := score(img, []string{"beach"}, []float32{0.9, 0.1, 0}); s < 0.9 {
+		t.Fatalf("similar embedding scored %f", s)
+	}
+	if s := score(img, []string{"beach"}, []float32{0, 1, 0}); s != 0 {
+		t.Fatalf("unrelated embedding scored %f", s)
+	}
+	// images without an embedding don't match queries with one, keyword scores don't compare
+	if s := score(&Image{Keywords: []string{"beach"}}, []string{"beach"}, []float32{1, 0, 0}); s != 0 {
+		t.Fatalf("image without an embedding scored %f", s)
+	}
+	// no query embedding, fall back to keywords
+	if s := score(img, []string{"beach"}, nil); s != 1 {
+		t.Fatalf("keyword match scored %f", s)
+	}
+}
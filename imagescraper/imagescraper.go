@@ -1,9 +1,15 @@
 package imagescraper
 
 import (
-	"io"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"git.openpunk.com/CPunch/copywriter/util"
@@ -12,6 +18,25 @@ import (
 
 var (
 	IMAGE_EXTENSIONS = []string{".jpg", ".jpeg", ".png", ".gif"}
+
+	// sites whose images all share a license, by host
+	SITE_LICENSES = map[string]License{
+		"stocksnap.io": {Name: "CC0", URL: "https://stocksnap.io/license"},
+	}
+
+	htmlTagRegex = regexp.MustCompile(`<[^>]*>`)
+)
+
+const (
+	COMMONS_API     = "https://commons.wikimedia.org/w/api.php"
+	COMMONS_WIDTH   = 1200 // width of the commons thumbnails we ask for
+	MAX_AUTHOR_LEN  = 100
+	MAX_CONTEXT_LEN = 200 // of the text around an image
+
+	// how freely an image can be used, lower is better
+	LICENSE_PUBLIC_DOMAIN = 0 // CC0 and public domain, no strings attached
+	LICENSE_ATTRIBUTION   = 1 // CC BY and friends, fine as long as we credit the author
+	LICENSE_UNKNOWN       = 2
 )
 
 /*
@@ -20,50 +45,157 @@ var (
  image, we still have it.
 */
 
-func validateURL(url string) bool {
-	// make request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return false
+type License struct {
+	Name string // eg. "CC0" or "CC BY-SA 4.0"
+	URL  string
+}
+
+// a scraped image and where it came from. anything we couldn't find out is left empty
+type Image struct {
+	URL        string
+	SourcePage string // the page the image was found on
+	Author     string
+	License    License
+	Alt        string
+	Context    string // text around the image on its page, or its description
+	Width      int    // 0 if unknown
+	Height     int
+
+	probe *probe // set once validated
+}
+
+// how freely the image's license lets us use it, see LICENSE_PUBLIC_DOMAIN
+func (img *Image) LicenseRank() int {
+	name := strings.ToLower(img.License.Name)
+	switch {
+	case name == "":
+		return LICENSE_UNKNOWN
+	case name == "cc0" || strings.Contains(name, "public domain") || name == "pdm":
+		return LICENSE_PUBLIC_DOMAIN
+	case strings.HasPrefix(name, "cc by") || strings.HasPrefix(name, "cc-by"):
+		// non-commercial and no-derivatives licenses don't work for us
+		for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == ' ' || r == '-' }) {
+			if part == "nc" || part == "nd" {
+				return LICENSE_UNKNOWN
+			}
+		}
+		return LICENSE_ATTRIBUTION
 	}
 
-	// set useragent
+	return LICENSE_UNKNOWN
+}
+
+// true if we know the image can be used, with or without attribution
+func (img *Image) Licensed() bool {
+	return img.LicenseRank() < LICENSE_UNKNOWN
+}
+
+// collapses whitespace and cuts the text down to max bytes
+func truncate(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > max {
+		text = strings.ToValidUTF8(text[:max], "")
+	}
+	return text
+}
+
+// strips the html commons puts in its metadata, eg. links to the author's user page
+func stripHTML(text string, max int) string {
+	return truncate(html.UnescapeString(htmlTagRegex.ReplaceAllString(text, "")), max)
+}
+
+/*
+	https://commons.wikimedia.org/w/api.php?action=query&generator=search&gsrnamespace=6&gsrsearch=...
+		&prop=imageinfo&iiprop=url|extmetadata&iiurlwidth=1200&format=json
+
+	{"query": {"pages": {"123": {"imageinfo": [{
+		"thumburl": "https://upload.wikimedia.org/...", "descriptionurl": "https://commons.wikimedia.org/wiki/File:...",
+		"extmetadata": {"Artist": {"value": "<a href=...>Jane Doe</a>"}, "LicenseShortName": {"value": "CC BY-SA 4.0"}, "LicenseUrl": {"value": "..."}}
+	}]}}}}
+*/
+
+type commonsMeta struct {
+	Value string `json:"value"`
+}
+
+type commonsResponse struct {
+	Query struct {
+		Pages map[string]struct {
+			ImageInfo []struct {
+				ThumbURL       string                 `json:"thumburl"`
+				ThumbWidth     int                    `json:"thumbwidth"`
+				ThumbHeight    int                    `json:"thumbheight"`
+				DescriptionURL string                 `json:"descriptionurl"`
+				ExtMetadata    map[string]commonsMeta `json:"extmetadata"`
+			} `json:"imageinfo"`
+		} `json:"pages"`
+	} `json:"query"`
+}
+
+// searches wikimedia commons, which has license and author info for every image
+func searchCommons(searchQuery string) ([]*Image, error) {
+	params := url.Values{
+		"action":       {"query"},
+		"generator":    {"search"},
+		"gsrnamespace": {"6"}, // files
+		"gsrsearch":    {searchQuery + " filetype:bitmap"},
+		"gsrlimit":     {"10"},
+		"prop":         {"imageinfo"},
+		"iiprop":       {"url|extmetadata"},
+		"iiurlwidth":   {fmt.Sprint(COMMONS_WIDTH)},
+		"format":       {"json"},
+	}
+
+	req, err := http.NewRequest("GET", COMMONS_API+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
 	req.Header.Set("User-Agent", util.USER_AGENT)
 
-	// make the request
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return false
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	// check status code
 	if resp.StatusCode != 200 {
-		return false
+		return nil, fmt.Errorf("Bad status code: %d", resp.StatusCode)
 	}
 
-	// response body too big or too small? helps keep logos and stuff out too
-	if resp.ContentLength > 10000000 || resp.ContentLength < 5000 {
-		return false
+	var result commonsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
 	}
 
-	// Read the response body into a byte slice
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return false
-	}
+	var images []*Image
+	for _, page := range result.Query.Pages {
+		for _, info := range page.ImageInfo {
+			if info.ThumbURL == "" {
+				continue
+			}
 
-	// check the mime type of the response body
-	if !strings.Contains(http.DetectContentType(body), "image") {
-		return false
+			images = append(images, &Image{
+				URL:        info.ThumbURL,
+				SourcePage: info.DescriptionURL,
+				Author:     stripHTML(info.ExtMetadata["Artist"].Value, MAX_AUTHOR_LEN),
+				License: License{
+					Name: info.ExtMetadata["LicenseShortName"].Value,
+					URL:  info.ExtMetadata["LicenseUrl"].Value,
+				},
+				Alt:     stripHTML(info.ExtMetadata["ObjectName"].Value, MAX_CONTEXT_LEN),
+				Context: stripHTML(info.ExtMetadata["ImageDescription"].Value, MAX_CONTEXT_LEN),
+				Width:   info.ThumbWidth,
+				Height:  info.ThumbHeight,
+			})
+		}
 	}
 
-	return true
+	return images, nil
 }
 
 // we scrape various image sites for images based on the search query
-func doImageSearch(searchQuery string) []string {
-	scrapedImages := []string{}
+func doImageSearch(searchQuery string) []*Image {
+	scrapedImages := []*Image{}
 
 	// make our search query url friendly
 	searchString := strings.Replace(searchQuery, " ", "-", -1)
@@ -73,21 +205,42 @@ func doImageSearch(searchQuery string) []string {
 	c.AllowURLRevisit = true
 	c.DisableCookies()
 
-	// scrape all images from a page
-	c.OnHTML("img[src]", func(e *colly.HTMLElement) {
-		src := e.Attr("src")
-		if src != "" && validateURL(src) {
-			// add the image to our list of scraped images
-			scrapedImages = append(scrapedImages, src)
+	// scrape all images from a page, along with whatever the page tells us about them
+	addImage := func(e *colly.HTMLElement, src string) {
+		src = e.Request.AbsoluteURL(src)
+		if src == "" {
+			return
+		}
+
+		img := &Image{
+			URL:        src,
+			SourcePage: e.Request.URL.String(),
+			Author:     e.Attr("data-author"),
+			License:    SITE_LICENSES[strings.TrimPrefix(e.Request.URL.Hostname(), "www.")],
+			Alt:        truncate(e.Attr("alt"), MAX_CONTEXT_LEN),
+			Context:    truncate(e.DOM.Closest("figure, li, article, div").Text(), MAX_CONTEXT_LEN),
 		}
+		img.Width, _ = strconv.Atoi(e.Attr("width"))
+		img.Height, _ = strconv.Atoi(e.Attr("height"))
+		if img.Alt == "" {
+			img.Alt = truncate(e.Attr("title"), MAX_CONTEXT_LEN)
+		}
+
+		// search results usually link to the image's own page
+		if href, ok := e.DOM.Closest("a[href]").Attr("href"); ok && !strings.HasPrefix(href, "#") {
+			img.SourcePage = e.Request.AbsoluteURL(href)
+		}
+
+		// add the image to our list of scraped images
+		scrapedImages = append(scrapedImages, img)
+	}
+
+	c.OnHTML("img[src]", func(e *colly.HTMLElement) {
+		addImage(e, e.Attr("src"))
 	})
 
 	c.OnHTML("img[data-src]", func(e *colly.HTMLElement) {
-		src := e.Attr("data-src")
-		if src != "" && validateURL(src) {
-			// add the image to our list of scraped images
-			scrapedImages = append(scrapedImages, src)
-		}
+		addImage(e, e.Attr("data-src"))
 	})
 
 	// some sites have different search query formats
@@ -97,18 +250,81 @@ func doImageSearch(searchQuery string) []string {
 	c.Visit("https://www.google.com/images?q=" + stocSnapQuery)
 	c.Visit("https://stocksnap.io/search/" + stocSnapQuery)
 
-	return scrapedImages
+	commonsImages, err := searchCommons(searchQuery)
+	if err != nil {
+		util.Warning("Failed to search wikimedia commons: %v", err)
+	}
+	scrapedImages = append(scrapedImages, commonsImages...)
+
+	return validate(scrapedImages)
 }
 
-func GetImageUrl(query string) string {
-	imgs := doImageSearch(query)
+// returns the image best fitting the query among the most freely licensed ones found,
+// as picked by the LLM. images it rejects are never returned. if licensedOnly is set, images we don't know the license of are
+// never returned. images with an excluded url are skipped, eg. ones that were tried already.
+// canceling ctx stops the ranking
+func GetImage(ctx context.Context, query string, licensedOnly bool, exclude ...string) (*Image, error) {
+	excluded := make(map[string]bool)
+	for _, url := range exclude {
+		excluded[url] = true
+	}
 
-	// TODO: replace this :(
-	if len(imgs) == 0 {
-		return "https://www.rd.com/wp-content/uploads/2020/11/GettyImages-889552354-e1606774439626.jpg"
+	var candidates []*Image
+	for _, img := range Search(query) {
+		if !excluded[img.URL] {
+			candidates = append(candidates, img)
+		}
 	}
 
-	// TODO: maybe ask GPT to select the best one ?
-	indx := rand.Intn(len(imgs))
-	return imgs[indx]
+	// try each license rank in turn, moving on if the LLM doesn't like any of them
+	for rank := LICENSE_PUBLIC_DOMAIN; rank <= LICENSE_UNKNOWN; rank++ {
+		if licensedOnly && rank == LICENSE_UNKNOWN {
+			break
+		}
+
+		var group []*Image
+		for _, img := range candidates {
+			if img.LicenseRank() == rank {
+				group = append(group, img)
+			}
+		}
+		if len(group) == 0 {
+			continue
+		}
+
+		// the LLM is shown MAX_CANDIDATES at a time, later batches are only ranked if
+		// nothing in the earlier ones fit
+		for start := 0; start < len(group); start += MAX_CANDIDATES {
+			batch := group[start:]
+			if len(batch) > MAX_CANDIDATES {
+				batch = batch[:MAX_CANDIDATES]
+			}
+
+			img, err := selectImage(ctx, query, batch)
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			} else if err != nil {
+				util.Warning("Failed to rank images, picking one at random: %v", err)
+				return batch[rand.Intn(len(batch))], nil
+			}
+			if img != nil {
+				return img, nil
+			}
+		}
+	}
+
+	// nothing fit, and the rejected images have no business being used
+	if licensedOnly {
+		return nil, fmt.Errorf("No licensed images found for '%s'", query)
+	}
+	return nil, fmt.Errorf("No suitable images found for '%s'", query)
+}
+
+// returns "" if no image was found
+func GetImageUrl(query string) string {
+	img, err := GetImage(context.Background(), query, false)
+	if err != nil {
+		return ""
+	}
+	return img.URL
 }
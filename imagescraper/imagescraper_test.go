@@ -1,10 +1,178 @@
 package imagescraper
 
 import (
+	"bytes"
 	"fmt"
+	"image"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetImageUrl(t *testing.T) {
 	fmt.Println(GetImageUrl("The Value of Time image"))
 }
+
+func TestLicenseRank(t *testing.T) {
+	ranks := map[string]int{
+		"CC0":                 LICENSE_PUBLIC_DOMAIN,
+		"Public domain":       LICENSE_PUBLIC_DOMAIN,
+		"CC BY 4.0":           LICENSE_ATTRIBUTION,
+		"CC BY-SA 3.0":        LICENSE_ATTRIBUTION,
+		"CC BY-NC-SA 2.0":     LICENSE_UNKNOWN,
+		"CC BY-ND 4.0":        LICENSE_UNKNOWN,
+		"":                    LICENSE_UNKNOWN,
+		"All rights reserved": LICENSE_UNKNOWN,
+	}
+
+	for name, rank := range ranks {
+		img := &Image{License: License{Name: name}}
+		if got := img.LicenseRank(); got != rank {
+			t.Errorf("'%s' ranked %d, expected %d", name, got, rank)
+		}
+	}
+}
+
+func TestStripHTML(t *testing.T) {
+	if got := stripHTML(`<a href="//commons.wikimedia.org/wiki/User:Jane">Jane &amp; John Doe</a>`, MAX_AUTHOR_LEN); got != "Jane & John Doe" {
+		t.Fatalf("got '%s'", got)
+	}
+}
+
+func TestRejectReason(t *testing.T) {
+	images := map[*Image]bool{
+		{URL: "https://example.com/oatmeal.jpg", Alt: "a bowl of oatmeal", Width: 1200, Height: 800}: false,
+		{URL: "https://example.com/oatmeal.jpg"}:                                                     false,
+		{URL: "https://example.com/site-logo.png", Width: 600, Height: 400}:                          true,
+		{URL: "https://example.com/a.jpg", Alt: "Company Icon"}:                                      true,
+		{URL: "https://media.istockphoto.com/id/123/photo.jpg"}:                                      true,
+		{URL: "https://example.com/a.jpg", Width: 200, Height: 150}:                                  true,
+		{URL: "https://example.com/a.jpg", Width: 1800, Height: 300}:                                 true,
+		{URL: "https://example.com/a.jpg", Width: 612, Height: 408}:                                  true,
+		{URL: "https://example.com/a.jpg", Width: 612, Height: 408, License: License{Name: "CC0"}}:   false,
+	}
+
+	for img, rejected := range images {
+		if reason := rejectReason(img); (reason != "") != rejected {
+			t.Errorf("%+v: got reason '%s'", img, reason)
+		}
+	}
+}
+
+func TestFilterCandidates(t *testing.T) {
+	candidates := filterCandidates([]*Image{
+		{URL: "https://example.com/a.jpg?w=800"},
+		{URL: "https://example.com/a.jpg?w=1200"},
+		{URL: "https://example.com/b.jpg", Alt: "oatmeal", Width: 800, Height: 600},
+		{URL: "https://example.com/c.jpg", Alt: "Oatmeal", Width: 800, Height: 600},
+		{URL: "https://example.com/logo.png"},
+		{URL: "https://commons.wikimedia.org/d.jpg", License: License{Name: "CC BY 4.0"}},
+	})
+
+	var urls []string
+	for _, img := range candidates {
+		urls = append(urls, img.URL)
+	}
+
+	expected := []string{"https://commons.wikimedia.org/d.jpg", "https://example.com/a.jpg?w=800", "https://example.com/b.jpg"}
+	if fmt.Sprint(urls) != fmt.Sprint(expected) {
+		t.Fatalf("got %v, expected %v", urls, expected)
+	}
+}
+
+func TestParseRanking(t *testing.T) {
+	rankings := map[string][]int{
+		"[3, 1]":            {2, 0},
+		"```json\n[2]\n```": {1},
+		"[0, 4, 2, 2, 9]":   {3, 1},
+		"[]":                nil,
+	}
+
+	for response, expected := range rankings {
+		got, err := parseRanking(response, 4)
+		if err != nil {
+			t.Fatalf("'%s': %v", response, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("'%s': got %v, expected %v", response, got, expected)
+		}
+	}
+
+	if _, err := parseRanking("the first one", 4); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	// noise doesn't compress, so this is well over PROBE_BYTES
+	img := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	rand.Read(img.Pix)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests, ranged, flaky int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Range") != "" {
+			atomic.AddInt32(&ranged, 1)
+		}
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "flaky.png") && atomic.AddInt32(&flaky, 1) == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.HasSuffix(r.URL.Path, ".png"):
+			http.ServeContent(w, r, "image.png", time.Time{}, bytes.NewReader(buf.Bytes()))
+		default:
+			http.ServeContent(w, r, "page.html", time.Time{}, strings.NewReader(strings.Repeat("<p>not an image</p>", 500)))
+		}
+	}))
+	defer server.Close()
+
+	imgs := validate([]*Image{{URL: server.URL + "/a.png", Width: 40, Height: 30}, {URL: server.URL + "/page.html"}, {URL: server.URL + "/flaky.png"}})
+	if len(imgs) != 1 {
+		t.Fatalf("expected 1 valid image, got %d", len(imgs))
+	}
+	if imgs[0].Width != 400 || imgs[0].Height != 300 {
+		t.Fatalf("expected the decoded dimensions, got %dx%d", imgs[0].Width, imgs[0].Height)
+	}
+
+	// only the failure that might not happen again is retried
+	imgs = validate([]*Image{{URL: server.URL + "/a.png"}, {URL: server.URL + "/page.html"}, {URL: server.URL + "/flaky.png"}})
+	if len(imgs) != 2 {
+		t.Fatalf("expected 2 valid images, got %d", len(imgs))
+	}
+	if requests != 4 {
+		t.Fatalf("expected 4 probes, got %d", requests)
+	}
+
+	data, err := imgs[0].Download()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, buf.Bytes()) {
+		t.Fatalf("downloaded %d bytes, expected %d", len(data), buf.Len())
+	}
+	if ranged != requests {
+		t.Fatalf("expected only ranged requests, got %d of %d", ranged, requests)
+	}
+}
+
+func TestDownloadTooBig(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// no content length, so the size isn't known up front
+		w.Write(bytes.Repeat([]byte{0}, MAX_IMAGE_BYTES+1))
+	}))
+	defer server.Close()
+
+	if _, err := (&Image{URL: server.URL}).Download(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,214 @@
+package imagescraper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"git.openpunk.com/CPunch/copywriter/prompts"
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	MAX_CANDIDATES = 10  // images shown to the LLM at once
+	MIN_IMAGE_SIZE = 300 // smaller images are thumbnails, icons and the like
+	MAX_ASPECT     = 3.0 // wider (or taller) images are banners
+)
+
+var (
+	// words in an image's url or alt text which give away that it isn't a photo
+	LOGO_WORDS = []string{"logo", "icon", "avatar", "sprite", "badge", "favicon", "banner", "button", "placeholder"}
+
+	// stock sites whose previews are watermarked
+	WATERMARK_HOSTS = []string{"shutterstock.com", "istockphoto.com", "gettyimages.com", "dreamstime.com", "alamy.com", "depositphotos.com", "123rf.com", "ftcdn.net", "bigstockphoto.com"}
+
+	// widths watermarked stock previews are usually served at (eg. 612 by istock)
+	WATERMARK_PREVIEW_WIDTHS = map[int]bool{450: true, 612: true, 626: true}
+)
+
+// says why the image is unsuitable, or "" if it isn't
+func rejectReason(img *Image) string {
+	u, err := url.Parse(img.URL)
+	if err != nil {
+		return "bad url"
+	}
+
+	text := strings.ToLower(path.Base(u.Path) + " " + img.Alt)
+	for _, word := range LOGO_WORDS {
+		if strings.Contains(text, word) {
+			return "looks like a " + word
+		}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, watermarked := range WATERMARK_HOSTS {
+		if host == watermarked || strings.HasSuffix(host, "."+watermarked) {
+			return "watermarked stock preview"
+		}
+	}
+
+	if img.Width > 0 && img.Height > 0 {
+		if img.Width < MIN_IMAGE_SIZE || img.Height < MIN_IMAGE_SIZE {
+			return "too small"
+		}
+
+		aspect := float64(img.Width) / float64(img.Height)
+		if aspect > MAX_ASPECT || aspect < 1/MAX_ASPECT {
+			return "banner sized"
+		}
+
+		// licensed images come from sources which don't watermark
+		if !img.Licensed() && WATERMARK_PREVIEW_WIDTHS[img.Width] {
+			return "watermarked stock preview size"
+		}
+	}
+
+	return ""
+}
+
+// the same picture is often linked with different query strings (eg. sizes), or
+// shown more than once on a page
+func dedupeKeys(img *Image) []string {
+	keys := []string{img.URL}
+	if u, err := url.Parse(img.URL); err == nil {
+		keys = append(keys, strings.ToLower(u.Host+u.Path))
+	}
+
+	if img.Alt != "" && img.Width > 0 {
+		keys = append(keys, fmt.Sprintf("%s %dx%d", strings.ToLower(img.Alt), img.Width, img.Height))
+	}
+	return keys
+}
+
+// drops unsuitable and duplicate images, and sorts the rest by license, most
+// freely licensed first
+func filterCandidates(imgs []*Image) []*Image {
+	seen := make(map[string]bool)
+	var candidates []*Image
+
+	for _, img := range imgs {
+		if reason := rejectReason(img); reason != "" {
+			continue
+		}
+
+		duplicate := false
+		keys := dedupeKeys(img)
+		for _, key := range keys {
+			duplicate = duplicate || seen[key]
+			seen[key] = true
+		}
+		if !duplicate {
+			candidates = append(candidates, img)
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].LicenseRank() < candidates[j].LicenseRank()
+	})
+	return candidates
+}
+
+// search results by query, so trying again (eg. after a duplicate) doesn't scrape again
+var searches sync.Map
+
+// scrapes images for the query, returning the suitable ones, most freely licensed first
+func Search(query string) []*Image {
+	if cached, ok := searches.Load(query); ok {
+		return cached.([]*Image)
+	}
+
+	// nothing found might just be a network hiccup, that's not worth remembering
+	candidates := filterCandidates(doImageSearch(query))
+	if len(candidates) > 0 {
+		searches.Store(query, candidates)
+	}
+	return candidates
+}
+
+// eg. '3. alt: "a bowl of oatmeal" | context: "..." | 1200x800 | commons.wikimedia.org'
+func (img *Image) describe(n int) string {
+	parts := []string{fmt.Sprintf("%d. alt: %q", n, img.Alt)}
+	if img.Context != "" {
+		parts = append(parts, fmt.Sprintf("context: %q", img.Context))
+	}
+	if img.Width > 0 && img.Height > 0 {
+		parts = append(parts, fmt.Sprintf("%dx%d", img.Width, img.Height))
+	}
+	if u, err := url.Parse(img.URL); err == nil {
+		parts = append(parts, u.Hostname())
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// parses the LLM's ranking, a json array of candidate numbers (starting at 1), into
+// indexes. numbers out of range or repeated are dropped
+func parseRanking(response string, count int) ([]int, error) {
+	response = strings.ReplaceAll(response, "```json", "")
+	response = strings.ReplaceAll(response, "```", "")
+
+	var numbers []int
+	if err := json.Unmarshal([]byte(strings.TrimSpace(response)), &numbers); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+	var ranking []int
+	for _, n := range numbers {
+		if n >= 1 && n <= count && !seen[n] {
+			seen[n] = true
+			ranking = append(ranking, n-1)
+		}
+	}
+	return ranking, nil
+}
+
+// asks the LLM which candidate fits the prompt best, judging by their alt text and
+// context. returns nil if it thinks none of them fit. only the first MAX_CANDIDATES are
+// shown, callers rank longer lists in batches
+func selectImage(ctx context.Context, prompt string, candidates []*Image) (*Image, error) {
+	if len(candidates) > MAX_CANDIDATES {
+		candidates = candidates[:MAX_CANDIDATES]
+	}
+
+	descriptions := make([]string, len(candidates))
+	for i, img := range candidates {
+		descriptions[i] = img.describe(i + 1)
+	}
+
+	text, err := prompts.Render(prompts.IMAGE_SELECT, prompts.Vars{ImagePrompt: prompt, Candidates: descriptions})
+	if err != nil {
+		return nil, err
+	}
+
+	var ranking []int
+	for i := 0; i < util.MAX_CHAT_RETRY; i++ {
+		var response string
+		response, err = util.GenerateResponse(util.ResponseOptions{
+			Ctx:       ctx,
+			MaxTokens: 100,
+			Prompt:    text,
+			Stub:      "[1]",
+			UseGPT4:   false,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if ranking, err = parseRanking(response, len(candidates)); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("bad image ranking: %w", err)
+	} else if len(ranking) == 0 {
+		return nil, nil
+	}
+	return candidates[ranking[0]], nil
+}
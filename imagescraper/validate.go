@@ -0,0 +1,217 @@
+package imagescraper
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	PROBE_BYTES     = 64 * 1024 // enough to sniff the type and read the dimensions of most images
+	MIN_IMAGE_BYTES = 5000      // helps keep logos and stuff out too
+	MAX_IMAGE_BYTES = 10000000
+	MAX_VALIDATORS  = 8 // candidates validated at once
+)
+
+/*
+	Instead of downloading every candidate, we only ask for the first PROBE_BYTES of each
+	(a 'Range: bytes=0-65535' request). The total size comes from the Content-Range (or
+	Content-Length) header, the mime type is sniffed from the bytes and the dimensions are
+	decoded from the image header. Whatever was downloaded is kept, so fetching the chosen
+	image only needs the rest of it, if anything.
+*/
+
+// what we learned about an image url
+type probe struct {
+	ok        bool
+	transient bool   // failed in a way that might not happen next time, eg. a timeout
+	data      []byte // the start of the image, or all of it if complete
+	complete  bool
+	width     int // 0 if the header couldn't be decoded
+	height    int
+}
+
+// probes by url, so images seen on several pages or in several searches are only checked
+// once. transient failures aren't kept
+var probes sync.Map
+
+func newImageRequest(url string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", util.USER_AGENT)
+	return req, nil
+}
+
+// the total size of the image, or -1 if the server didn't say
+func responseSize(resp *http.Response) int64 {
+	if resp.StatusCode != http.StatusPartialContent {
+		return resp.ContentLength
+	}
+
+	// eg. 'bytes 0-65535/123456'
+	contentRange := resp.Header.Get("Content-Range")
+	total := contentRange[strings.LastIndex(contentRange, "/")+1:]
+	if size, err := strconv.ParseInt(total, 10, 64); err == nil {
+		return size
+	}
+	return -1
+}
+
+func probeURL(url string) *probe {
+	req, err := newImageRequest(url)
+	if err != nil {
+		return &probe{}
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", PROBE_BYTES-1))
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return &probe{transient: true}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &probe{transient: true}
+	}
+
+	// servers which don't support ranges just send the whole thing
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return &probe{}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, PROBE_BYTES))
+	if err != nil {
+		return &probe{transient: true}
+	}
+
+	size := responseSize(resp)
+	complete := int64(len(data)) == size || (size < 0 && len(data) < PROBE_BYTES)
+	if complete {
+		size = int64(len(data))
+	}
+
+	if size >= 0 && (size > MAX_IMAGE_BYTES || size < MIN_IMAGE_BYTES) {
+		return &probe{}
+	}
+
+	if !strings.HasPrefix(http.DetectContentType(data), "image/") {
+		return &probe{}
+	}
+
+	p := &probe{ok: true, data: data, complete: complete}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		p.width, p.height = config.Width, config.Height
+	}
+	return p
+}
+
+// probes the url, or returns the cached probe. safe to call concurrently
+func getProbe(url string) *probe {
+	if cached, ok := probes.Load(url); ok {
+		return cached.(*probe)
+	}
+
+	p := probeURL(url)
+	if !p.transient {
+		probes.Store(url, p)
+	}
+	return p
+}
+
+// probes the images concurrently, returning the ones which are really images, in order.
+// their dimensions are filled in from the image itself
+func validate(imgs []*Image) []*Image {
+	valid := make([]bool, len(imgs))
+	sem := make(chan struct{}, MAX_VALIDATORS)
+
+	var wg sync.WaitGroup
+	for i, img := range imgs {
+		wg.Add(1)
+		go func(i int, img *Image) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			p := getProbe(img.URL)
+			if !p.ok {
+				return
+			}
+
+			img.probe = p
+			if p.width > 0 && p.height > 0 {
+				img.Width, img.Height = p.width, p.height
+			}
+			valid[i] = true
+		}(i, img)
+	}
+	wg.Wait()
+
+	var validated []*Image
+	for i, img := range imgs {
+		if valid[i] {
+			validated = append(validated, img)
+		}
+	}
+	return validated
+}
+
+// downloads the image, reusing whatever was fetched while validating it
+func (img *Image) Download() ([]byte, error) {
+	var data []byte
+	if img.probe != nil {
+		if img.probe.complete {
+			return img.probe.data, nil
+		}
+		data = img.probe.data
+	}
+
+	req, err := newImageRequest(img.URL)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", len(data)))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		// the range was ignored, this is the whole image
+		data = nil
+	default:
+		return nil, fmt.Errorf("Bad status code: %d", resp.StatusCode)
+	}
+
+	// the size wasn't always known while validating, don't write out a cut off image
+	limit := int64(MAX_IMAGE_BYTES - len(data))
+	rest, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(rest)) > limit {
+		return nil, fmt.Errorf("Image is bigger than %d bytes", MAX_IMAGE_BYTES)
+	}
+
+	return append(data[:len(data):len(data)], rest...), nil
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/google/subcommands"
+	"github.com/groovili/gogtrends"
+)
+
+var (
+	HUGO_CONFIG_FILES = []string{"hugo.toml", "hugo.yaml", "hugo.yml", "hugo.json", "config.toml", "config.yaml", "config.yml", "config.json"}
+	HUGO_POST_DIRS    = []string{"posts", "post", "blog"}
+
+	contentDirRegex = regexp.MustCompile(`(?m)^\s*"?contentDir"?\s*[:=]\s*"?([^"\s,]+)"?`)
+)
+
+type InitCommand struct {
+	OutFile string
+	HugoDir string
+	*prompter
+}
+
+type hugoProject struct {
+	ConfigFile  string
+	ContentDir  string // where posts should be written
+	FrontMatter string // "yaml", "toml" or "json"
+}
+
+func (*InitCommand) Name() string     { return "init" }
+func (*InitCommand) Synopsis() string { return "Scaffold a config for a new site" }
+func (i *InitCommand) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&i.OutFile, "o", "copywriter.ini", "config file to write, site profiles are appended if it already exists")
+	f.StringVar(&i.HugoDir, "hugo", ".", "hugo project to detect output paths from")
+}
+
+func (*InitCommand) Usage() string {
+	return "init [-o copywriter.ini] [-hugo projectdir]:\n\tInteractively write a commented config for a new site.\n"
+}
+
+// looks for a hugo project in dir, returns nil if there isn't one
+func detectHugoProject(dir string) *hugoProject {
+	project := &hugoProject{FrontMatter: "yaml"}
+	for _, name := range HUGO_CONFIG_FILES {
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			project.ConfigFile = path.Join(dir, name)
+			break
+		}
+	}
+
+	if project.ConfigFile == "" {
+		return nil
+	}
+
+	// content directory, defaults to 'content'
+	contentDir := "content"
+	if data, err := os.ReadFile(project.ConfigFile); err == nil {
+		if match := contentDirRegex.FindSubmatch(data); match != nil {
+			contentDir = string(match[1])
+		}
+	}
+
+	project.ContentDir = path.Join(dir, contentDir)
+	for _, postDir := range HUGO_POST_DIRS {
+		if info, err := os.Stat(path.Join(project.ContentDir, postDir)); err == nil && info.IsDir() {
+			project.ContentDir = path.Join(project.ContentDir, postDir)
+			break
+		}
+	}
+
+	// front matter format used by the default archetype
+	if data, err := os.ReadFile(path.Join(dir, "archetypes", "default.md")); err == nil {
+		switch text := strings.TrimSpace(string(data)); {
+		case strings.HasPrefix(text, "+++"):
+			project.FrontMatter = "toml"
+		case strings.HasPrefix(text, "{"):
+			project.FrontMatter = "json"
+		}
+	}
+
+	return project
+}
+
+// quotes an ini value, go-ini doesn't support escapes so values with quotes are backticked
+func iniQuote(value string) string {
+	if strings.Contains(value, "\"") {
+		return "`" + value + "`"
+	}
+	return "\"" + value + "\""
+}
+
+func trendCategories() []string {
+	var categories []string
+	for code, name := range gogtrends.TrendsCategories() {
+		categories = append(categories, fmt.Sprintf("%s (%s)", code, name))
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+func (i *InitCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	i.prompter = newPrompter()
+
+	_, err := os.Stat(i.OutFile)
+	appending := err == nil
+
+	outDir := DEFAULT_OUT_DIR
+	project := detectHugoProject(i.HugoDir)
+	if project != nil {
+		util.Info("Found hugo project '%s', posts will be written to '%s'", project.ConfigFile, project.ContentDir)
+		if project.FrontMatter != "yaml" {
+			util.Warning("Archetypes use %s front matter, copywriter writes yaml front matter (hugo accepts both)", project.FrontMatter)
+		}
+		outDir = project.ContentDir
+	}
+
+	// when appending to an existing config, the new site becomes a profile
+	site := ""
+	if appending {
+		util.Info("'%s' already exists, the new site will be appended as a site profile", i.OutFile)
+		site = i.ask("Site name", "")
+		for site == "" || strings.ContainsAny(site, ". []") {
+			util.Warning("Site names can't be empty or contain '.', ' ', '[' or ']'")
+			site = i.ask("Site name", "")
+		}
+	}
+
+	outDir = i.ask("Output directory", outDir)
+	custom := i.ask("Describe the blog (used as the custom prompt)", "")
+	author := i.ask("Author", DEFAULT_AUTHOR)
+	topicType := i.askChoice("Topic type", []string{TOPIC_TYPE_TRENDS, TOPIC_TYPE_NEWS, TOPIC_TYPE_QUEUE}, TOPIC_TYPE_TRENDS)
+
+	trend := DEFAULT_TRENDING_CATEGORY
+	queue := ""
+	if topicType == TOPIC_TYPE_QUEUE {
+		queue = i.ask("Queue file", "keywords.txt")
+	} else {
+		fmt.Println("Trend categories:")
+		for _, category := range trendCategories() {
+			fmt.Printf("\t%s\n", category)
+		}
+
+		for {
+			trend = i.ask("Trend category", DEFAULT_TRENDING_CATEGORY)
+			if _, ok := gogtrends.TrendsCategories()[trend]; ok {
+				break
+			}
+			util.Warning("Unknown trend category '%s'", trend)
+		}
+	}
+
+	image := i.ask("Image style appended to image prompts (eg. 'cinematic, dramatic')", "")
+	imageProvider := i.askChoice("Image provider", []string{IMAGE_PROVIDER_AUTO, IMAGE_PROVIDER_REPLICATE, IMAGE_PROVIDER_SCRAPER, IMAGE_PROVIDER_LIBRARY}, IMAGE_PROVIDER_AUTO)
+	library := ""
+	if imageProvider == IMAGE_PROVIDER_LIBRARY {
+		library = i.ask("Image library directory", "images")
+	}
+
+	// build the config
+	var sb strings.Builder
+	if appending {
+		fmt.Fprintf(&sb, "\n[%s%s]\n", SITE_SECTION_PREFIX, site)
+	} else {
+		fmt.Fprintf(&sb, "# generated by 'copywriter init', see the README for every option\n")
+	}
+	if project != nil {
+		fmt.Fprintf(&sb, "# hugo project: %s (%s front matter)\n", project.ConfigFile, project.FrontMatter)
+	}
+	fmt.Fprintf(&sb, "out = %s # directory posts are written to\n", iniQuote(outDir))
+	fmt.Fprintf(&sb, "custom = %s # describes the blog to GPT\n", iniQuote(custom))
+	fmt.Fprintf(&sb, "author = %s\n", iniQuote(author))
+	fmt.Fprintf(&sb, "topicType = %s # 'trends', 'news' or 'queue'\n", iniQuote(topicType))
+	if topicType == TOPIC_TYPE_QUEUE {
+		fmt.Fprintf(&sb, "queue = %s # one keyword list or brief per line\n", iniQuote(queue))
+	} else {
+		fmt.Fprintf(&sb, "# trends are scraped from https://trends.google.com/trends/trendingsearches/realtime?geo=US&hl=en-US&category=%s\n", trend)
+		fmt.Fprintf(&sb, "trend = %s\n", iniQuote(trend))
+	}
+	if image != "" {
+		fmt.Fprintf(&sb, "image = %s # appended to image prompts (applies to searches as well)\n", iniQuote(image))
+	} else {
+		fmt.Fprintf(&sb, "# image = \"cinematic, dramatic\" # appended to image prompts (applies to searches as well)\n")
+	}
+	fmt.Fprintf(&sb, "imageProvider = %s # 'auto' uses replicate if REPLICATE_API_KEY is set, otherwise the scraper\n", iniQuote(imageProvider))
+	if library != "" {
+		fmt.Fprintf(&sb, "library = %s # images (and sidecar descriptions) used by the 'library' provider\n", iniQuote(library))
+	}
+
+	// write it
+	file, err := os.OpenFile(i.OutFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		util.Fail("Failed to open '%s': %v", i.OutFile, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(sb.String()); err != nil {
+		util.Fail("Failed to write '%s': %v", i.OutFile, err)
+	}
+
+	if appending {
+		util.Success("Added site '%s' to '%s', use it with '-config %s -site %s'", site, i.OutFile, i.OutFile, site)
+	} else {
+		util.Success("Wrote '%s', use it with '-config %s'", i.OutFile, i.OutFile)
+	}
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	STRUCTURED_DATA_FILE_NAME = "schema.json"
+	JSONLD_PARAM              = "jsonld"
+)
+
+var (
+	SCHEMA_TYPES = []string{"Article", "BlogPosting", "NewsArticle", "TechArticle"}
+)
+
+/*
+	schema.org structured data for the post, as a JSON-LD graph:
+
+	{
+	  "@context": "https://schema.org",
+	  "@graph": [
+	    {"@type": "BlogPosting", "headline": "...", "image": "https://example.com/posts/<slug>/file_1.jpg", "author": {...}, ...},
+	    {"@type": "FAQPage", "mainEntity": [{"@type": "Question", ...}]} // only if the post has an FAQ
+	  ]
+	}
+
+	schema.org wants an absolute image url, so the image is left out unless the config
+	has a base url to build it from
+*/
+
+func isSchemaType(typ string) bool {
+	for _, t := range SCHEMA_TYPES {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// converts a front matter date (see util.GetTimeString) to ISO 8601
+func isoDate(date string) string {
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05", date, time.Local); err == nil {
+		return t.Format(time.RFC3339)
+	}
+	return date
+}
+
+// the absolute url of an image in the page bundle, empty if there's no base url to
+// build it from
+func (bw *BlogWriter) imageURL(fileName string) string {
+	if bw.config.BaseURL == "" || fileName == "" {
+		return ""
+	}
+	return strings.TrimSuffix(bw.config.BaseURL, "/") + "/" + path.Base(bw.outDir) + "/" + fileName
+}
+
+// builds the JSON-LD graph for the post. modified may be empty
+func (bw *BlogWriter) structuredData(published, modified string) map[string]interface{} {
+	if modified == "" {
+		modified = published
+	}
+
+	var keywords []string
+	json.Unmarshal([]byte(bw.Tags), &keywords)
+	if bw.SEO != nil && bw.SEO.FocusKeyword != "" {
+		keywords = append([]string{bw.SEO.FocusKeyword}, keywords...)
+	}
+
+	article := map[string]interface{}{
+		"@type":         bw.config.SchemaType,
+		"headline":      bw.Title,
+		"author":        map[string]interface{}{"@type": "Person", "name": bw.Author},
+		"datePublished": isoDate(published),
+		"dateModified":  isoDate(modified),
+		"keywords":      strings.Join(keywords, ", "),
+	}
+	if bw.SEO != nil {
+		article["description"] = bw.SEO.Description
+	}
+	if image := bw.imageURL(bw.Thumbnail); image != "" {
+		article["image"] = image
+	}
+
+	graph := []interface{}{article}
+	if faq := findFAQ(bw.Content); len(faq) > 0 {
+		var questions []interface{}
+		for _, entry := range faq {
+			questions = append(questions, map[string]interface{}{
+				"@type":          "Question",
+				"name":           entry.Question,
+				"acceptedAnswer": map[string]interface{}{"@type": "Answer", "text": entry.Answer},
+			})
+		}
+
+		graph = append(graph, map[string]interface{}{
+			"@type":      "FAQPage",
+			"mainEntity": questions,
+		})
+	}
+
+	return map[string]interface{}{
+		"@context": "https://schema.org",
+		"@graph":   graph,
+	}
+}
+
+// front matter line for the structured data, empty unless it's written to the front matter
+func (bw *BlogWriter) structuredDataHeader(published string) string {
+	if bw.config.StructuredData != STRUCTURED_DATA_FRONTMATTER {
+		return ""
+	}
+
+	// json is valid yaml, so the graph fits on one line
+	data, err := json.Marshal(bw.structuredData(published, ""))
+	if err != nil {
+		util.Warning("Failed to encode structured data: %v", err)
+		return ""
+	}
+	return fmt.Sprintf("%s: %s\n", JSONLD_PARAM, data)
+}
+
+// writes schema.json to the page bundle, if that's where structured data goes
+func (bw *BlogWriter) writeStructuredData(published, modified string) error {
+	if bw.config.StructuredData != STRUCTURED_DATA_FILE {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(bw.structuredData(published, modified), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	filePath := path.Join(bw.outDir, STRUCTURED_DATA_FILE_NAME)
+	if util.IsDryRun() {
+		util.Info("Structured data would be written to '%s'", filePath)
+		return nil
+	}
+
+	util.Info("Writing structured data to '%s'...", filePath)
+	return os.WriteFile(filePath, data, 0644)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"git.openpunk.com/CPunch/copywriter/imagelibrary"
+	"git.openpunk.com/CPunch/copywriter/thumbnail"
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	CAPTION_WIDTH  = 768 // library images are scaled down to this before being captioned
+	CAPTION_PROMPT = "Describe this photo in one or two sentences, mentioning the subject, setting and mood."
+)
+
+var (
+	// opened libraries by directory, shared by every post so usage is tracked across sites
+	imageLibraries   = make(map[string]*imagelibrary.Library)
+	imageLibrariesMu sync.Mutex
+)
+
+// describes the image with the captioner model
+func captionImage(ctx context.Context, config *ConfigData, imagePath string) (string, error) {
+	img, err := thumbnail.Load(imagePath)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := thumbnail.EncodeJPEG(&buf, thumbnail.Flatten(thumbnail.FitWidth(img, CAPTION_WIDTH))); err != nil {
+		return "", err
+	}
+
+	prediction, _, err := runModel(ctx, config, config.Captioner, map[string]interface{}{
+		"image":  imageDataURI(buf.Bytes()),
+		"prompt": CAPTION_PROMPT,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	caption, err := prediction.OutputText()
+	if err != nil {
+		return "", err
+	}
+
+	// some captioning models prefix their output
+	return strings.TrimSpace(strings.TrimPrefix(caption, "Caption:")), nil
+}
+
+// the hooks used while indexing the library, depending on the config
+func libraryOptions(ctx context.Context, config *ConfigData) imagelibrary.Options {
+	var opts imagelibrary.Options
+	if config.Captioner != "" {
+		opts.Describe = func(imagePath string) (string, error) {
+			return captionImage(ctx, config, imagePath)
+		}
+	}
+	if config.LibraryEmbed {
+		opts.Embed = func(text string) ([]float32, error) {
+			return util.EmbedContext(ctx, text)
+		}
+	}
+
+	return opts
+}
+
+// a library image copied into the post. it stays reserved until the post is written
+type libraryPick struct {
+	File    string
+	Library *imagelibrary.Library
+	Image   *imagelibrary.Image
+}
+
+// opens (and indexes) the configured library once per directory
+func imageLibrary(ctx context.Context, config *ConfigData) (*imagelibrary.Library, error) {
+	imageLibrariesMu.Lock()
+	defer imageLibrariesMu.Unlock()
+
+	if library, ok := imageLibraries[config.LibraryDir]; ok {
+		return library, nil
+	}
+
+	util.Info("Indexing image library '%s'...", config.LibraryDir)
+	library, err := imagelibrary.Open(config.LibraryDir, libraryOptions(ctx, config))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to index image library: %v", err)
+	}
+
+	imageLibraries[config.LibraryDir] = library
+	return library, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// copies the library image best matching the prompt to filePath, returning its credit
+// if its sidecar has any attribution. found is false if nothing in the library matches,
+// or everything that does was used recently
+func (bw *BlogWriter) copyFromLibrary(prompt, filePath string) (found bool, credit *Credit, err error) {
+	library, err := imageLibrary(bw.ctx, bw.config)
+	if err != nil {
+		return false, nil, err
+	}
+
+	var embedding []float32
+	if bw.config.LibraryEmbed {
+		if embedding, err = util.EmbedContext(bw.ctx, prompt); err != nil {
+			return false, nil, err
+		}
+	}
+
+	// images used by this post count as recently used too
+	img := library.Pick(prompt, embedding, bw.started.AddDate(0, 0, -bw.config.LibraryReuseDays))
+	if img == nil {
+		return false, nil, nil
+	}
+
+	util.Info("Using '%s' from the image library...", img.File)
+	if err := copyFile(library.Path(img), filePath); err != nil {
+		library.Release(img)
+		return false, nil, fmt.Errorf("Failed to copy '%s': %v", img.File, err)
+	}
+
+	// a replaced image (eg. a duplicate) stays reserved, so it isn't picked again
+	bw.libraryPicksMu.Lock()
+	bw.libraryPicks = append(bw.libraryPicks, libraryPick{File: path.Base(filePath), Library: library, Image: img})
+	bw.libraryPicksMu.Unlock()
+
+	if img.Author != "" || img.License != "" || img.Source != "" {
+		file := path.Base(filePath)
+		credit = &Credit{File: file, Source: img.Source, Author: img.Author, License: img.License, LicenseURL: img.LicenseURL}
+	}
+	return true, credit, nil
+}
+
+// marks the library images the post ended up with as used, the others are released
+func (bw *BlogWriter) keepLibraryImages() {
+	bw.libraryPicksMu.Lock()
+	defer bw.libraryPicksMu.Unlock()
+
+	// the last image copied to a file is the one in it
+	current := make(map[string]libraryPick)
+	for _, pick := range bw.libraryPicks {
+		current[pick.File] = pick
+	}
+
+	kept := make(map[*imagelibrary.Library][]*imagelibrary.Image)
+	for _, img := range bw.images() {
+		if pick, ok := current[img.File]; ok {
+			kept[pick.Library] = append(kept[pick.Library], pick.Image)
+		}
+	}
+
+	for library, imgs := range kept {
+		if err := library.Keep(imgs...); err != nil {
+			util.Warning("Failed to update image library index: %v", err)
+		}
+	}
+
+	for _, pick := range bw.libraryPicks {
+		pick.Library.Release(pick.Image)
+	}
+	bw.libraryPicks = nil
+}
+
+// releases the library images copied to the file, or every file if it's empty
+func (bw *BlogWriter) releaseLibraryImages(fileName string) {
+	bw.libraryPicksMu.Lock()
+	defer bw.libraryPicksMu.Unlock()
+
+	var picks []libraryPick
+	for _, pick := range bw.libraryPicks {
+		if fileName == "" || pick.File == fileName {
+			pick.Library.Release(pick.Image)
+		} else {
+			picks = append(picks, pick)
+		}
+	}
+	bw.libraryPicks = picks
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/google/subcommands"
+)
+
+type LibraryCommand struct {
+	Search string
+}
+
+func (*LibraryCommand) Name() string     { return "library" }
+func (*LibraryCommand) Synopsis() string { return "Index the local image library" }
+func (l *LibraryCommand) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&l.Search, "search", "", "list the images matching an image prompt")
+}
+
+func (*LibraryCommand) Usage() string {
+	return "library [-search <prompt>]:\n\tIndex new and changed images in the image library, describing them with the captioner if they have no sidecar description. With -search, list the images best matching the prompt.\n"
+}
+
+func (l *LibraryCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	config := ctx.Value("conf").(*ConfigData)
+	if config.LibraryDir == "" {
+		util.Fail("No image library configured, set 'library'")
+	}
+
+	library, err := imageLibrary(ctx, config)
+	if err != nil {
+		util.Fail("%v", err)
+	}
+
+	if l.Search == "" {
+		util.Success("Indexed %d images in '%s'", len(library.Images), config.LibraryDir)
+		return subcommands.ExitSuccess
+	}
+
+	var embedding []float32
+	if config.LibraryEmbed {
+		if embedding, err = util.EmbedContext(ctx, l.Search); err != nil {
+			util.Fail("%v", err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SCORE\tFILE\tLAST USED\tDESCRIPTION")
+	for _, match := range library.Search(l.Search, embedding) {
+		lastUsed := "never"
+		if match.Image.LastUsed != 0 {
+			lastUsed = time.Unix(match.Image.LastUsed, 0).Format(time.DateOnly)
+		}
+		fmt.Fprintf(w, "%.2f\t%s\t%s\t%s\n", match.Score, match.Image.File, lastUsed, match.Image.Description)
+	}
+	w.Flush()
+
+	return subcommands.ExitSuccess
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/google/subcommands"
+)
+
+type ListCommand struct {
+	Drafts bool
+}
+
+func (*ListCommand) Name() string     { return "list" }
+func (*ListCommand) Synopsis() string { return "List generated posts" }
+func (l *ListCommand) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&l.Drafts, "drafts", false, "only list drafts")
+}
+
+func (*ListCommand) Usage() string {
+	return "list [-drafts]:\n\tList the posts in the staging and output directories.\n"
+}
+
+// returns every post in the staging and output directories
+func loadAllPosts(config *ConfigData) []*Post {
+	var posts []*Post
+	for _, dir := range []string{config.StagingDir, config.OutDir} {
+		if dir == "" {
+			continue
+		}
+
+		dirPosts, err := LoadPosts(dir)
+		if err != nil && !os.IsNotExist(err) {
+			util.Warning("Failed to read posts in '%s': %v", dir, err)
+		}
+		posts = append(posts, dirPosts...)
+	}
+
+	return posts
+}
+
+func (l *ListCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	config := ctx.Value("conf").(*ConfigData)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SLUG\tTITLE\tDATE\tDRAFT\tTAGS\tDIRECTORY")
+	for _, post := range loadAllPosts(config) {
+		var draft bool
+		post.Decode("draft", &draft)
+		if l.Drafts && !draft {
+			continue
+		}
+
+		var tags []string
+		post.Decode("tags", &tags)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\n", post.Slug(), post.Get("title"), post.Get("date"), draft, strings.Join(tags, ", "), post.Dir)
+	}
+	w.Flush()
+
+	return subcommands.ExitSuccess
+}
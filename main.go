@@ -4,33 +4,69 @@ import (
 	"context"
 	"flag"
 	"os"
+	"os/signal"
 
+	"git.openpunk.com/CPunch/copywriter/prompts"
+	"git.openpunk.com/CPunch/copywriter/util"
 	"github.com/google/subcommands"
 )
 
 func main() {
-	conf := flag.String("config", "", "copywriter config file")
+	conf := flag.String("config", util.GetEnv("COPYWRITER_CONFIG", ""), "copywriter config file")
 	subcommands.ImportantFlag("config")
-	trnd := flag.String("trend", "all", "trending category")
+	flag.String("trend", DEFAULT_TRENDING_CATEGORY, "trending category")
 	subcommands.ImportantFlag("trend")
-	cust := flag.String("custom", "", "custom prompt")
+	flag.String("custom", "", "custom prompt")
 	subcommands.ImportantFlag("custom")
-	imgs := flag.String("image", "", "image style appended to image prompt")
+	flag.String("image", "", "image style appended to image prompt")
 	subcommands.ImportantFlag("image")
-	trndTopic := flag.String("trend-topic", "trends", "topic type for trending category")
+	flag.String("trend-topic", TOPIC_TYPE_TRENDS, "topic type for trending category")
 	subcommands.ImportantFlag("trend-topic")
+	site := flag.String("site", util.GetEnv("COPYWRITER_SITE", ""), "site profile from the config file")
+	subcommands.ImportantFlag("site")
+	flag.String("queue", "", "keyword/brief queue file used by the 'queue' topic type")
 	subcommands.Register(subcommands.HelpCommand(), "")
 	subcommands.Register(subcommands.FlagsCommand(), "")
 	subcommands.Register(subcommands.CommandsCommand(), "")
 	subcommands.Register(&WriteCommand{}, "")
+	subcommands.Register(&ConfigCommand{}, "")
+	subcommands.Register(&InitCommand{}, "")
+	subcommands.Register(&ListCommand{}, "")
+	subcommands.Register(&PublishCommand{}, "")
+	subcommands.Register(&RegenCommand{}, "")
+	subcommands.Register(&RefreshCommand{}, "")
+	subcommands.Register(&LibraryCommand{}, "")
 	flag.Parse()
 
-	cfg := NewConfig(*trnd, *cust, *imgs, *trndTopic)
+	// only explicitly passed flags override the config file and environment
+	flags := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if _, ok := CONFIG_FLAGS[f.Name]; ok {
+			flags[f.Name] = f.Value.String()
+		}
+	})
+
+	cfg := NewConfig(flags)
 	if *conf != "" {
 		cfg.LoadConfig(*conf)
 	}
 
-	ctx := context.WithValue(context.Background(), "conf", cfg)
+	if *site != "" {
+		var err error
+		if cfg, err = cfg.ForSite(*site); err != nil {
+			util.Fail("%v", err)
+		}
+	}
+
+	prompts.Configure(cfg.PromptDir, cfg.Locale)
+	// the first ctrl+c cancels in-flight work (eg. replicate predictions), the second kills us
+	sigCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-sigCtx.Done()
+		stop()
+	}()
+
+	ctx := context.WithValue(sigCtx, "conf", cfg)
 
 	os.Exit(int(subcommands.Execute(ctx)))
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// matches injected images, eg. '![a bowl of oatmeal](file_2.jpg)'
+	imageRegex = regexp.MustCompile(`!\[([^\]]*)\]\(([^)\s]+)\)`)
+)
+
+// a '##' section of an article. the text before the first heading is a section
+// without a heading
+type mdSection struct {
+	Heading string
+	Text    string // including the heading line
+}
+
+type mdImage struct {
+	Alt  string // the prompt the image was generated from
+	File string
+}
+
+func splitSections(markdown string) []mdSection {
+	var sections []mdSection
+	var current []string
+	heading := ""
+
+	flush := func() {
+		if len(current) > 0 {
+			sections = append(sections, mdSection{Heading: heading, Text: strings.Join(current, "\n")})
+		}
+	}
+
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(line, "## ") {
+			flush()
+			current = nil
+			heading = strings.TrimSpace(strings.TrimPrefix(line, "## "))
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return sections
+}
+
+func joinSections(sections []mdSection) string {
+	texts := make([]string, len(sections))
+	for i, section := range sections {
+		texts[i] = section.Text
+	}
+
+	return strings.Join(texts, "\n")
+}
+
+// returns the injected images in the markdown, in order
+func findImages(markdown string) []mdImage {
+	var images []mdImage
+	for _, match := range imageRegex.FindAllStringSubmatch(markdown, -1) {
+		images = append(images, mdImage{Alt: match[1], File: match[2]})
+	}
+
+	return images
+}
+
+// changes the alt text of the n'th image (starting at 0) in the markdown. brackets are
+// dropped from the alt text, they'd end it early
+func setImageAlt(markdown string, n int, alt string) string {
+	matches := imageRegex.FindAllStringSubmatchIndex(markdown, -1)
+	if n < 0 || n >= len(matches) {
+		return markdown
+	}
+
+	alt = strings.NewReplacer("[", "", "]", "").Replace(alt)
+	start, end := matches[n][2], matches[n][3]
+	return markdown[:start] + alt + markdown[end:]
+}
+
+// a question from the article's FAQ section
+type faqEntry struct {
+	Question string
+	Answer   string
+}
+
+// returns the questions in the '## FAQ' (or '## Frequently Asked Questions') section.
+// each question is a '###' heading followed by its answer
+func findFAQ(markdown string) []faqEntry {
+	var entries []faqEntry
+	for _, section := range splitSections(markdown) {
+		heading := strings.ToLower(section.Heading)
+		if !strings.Contains(heading, "faq") && !strings.Contains(heading, "frequently asked") {
+			continue
+		}
+
+		var current *faqEntry
+		var answer []string
+		flush := func() {
+			if current != nil {
+				current.Answer = strings.TrimSpace(strings.Join(answer, "\n"))
+				entries = append(entries, *current)
+			}
+			answer = nil
+		}
+
+		for _, line := range strings.Split(section.Text, "\n")[1:] {
+			switch {
+			case strings.HasPrefix(line, "### "):
+				flush()
+				current = &faqEntry{Question: strings.TrimSpace(strings.TrimPrefix(line, "### "))}
+			case current != nil && !imageRegex.MatchString(line):
+				answer = append(answer, line)
+			}
+		}
+		flush()
+	}
+
+	return entries
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/prompts"
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	TAKEAWAYS_HEADING = "Key Takeaways"
+	FAQ_HEADING       = "Frequently Asked Questions"
+)
+
+/*
+	Optional content modules, each generated from the finished article in its own
+	call and switched on by the 'takeaways' and 'faq' options:
+
+	<introduction>
+
+	## Key Takeaways
+
+	- ...
+
+	<article>
+
+	## Frequently Asked Questions
+
+	### <question>
+
+	<answer>
+*/
+
+// asks for a json value, retrying until it unmarshals into v
+func (bw *BlogWriter) genJSON(name, stub string, maxTokens int, v interface{}) error {
+	vars := bw.promptVars()
+	vars.Content = bw.Content
+	prompt, err := prompts.Render(name, vars)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < MAX_RETRY; i++ {
+		response, err := util.GenerateResponse(util.ResponseOptions{
+			Ctx:       bw.ctx,
+			MaxTokens: maxTokens,
+			Prompt:    prompt,
+			Stub:      stub,
+			UseGPT4:   false,
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal([]byte(strings.ReplaceAll(response, "```", "")), v); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("GPT failed to generate valid json for '%s'", name)
+}
+
+// inserts a key takeaways list between the introduction and the first section
+func (bw *BlogWriter) addTakeaways() error {
+	util.Info("Generating key takeaways...")
+	var takeaways []string
+	if err := bw.genJSON(prompts.TAKEAWAYS, `["This is a dry run."]`, 300, &takeaways); err != nil {
+		return err
+	}
+
+	if len(takeaways) == 0 {
+		return nil
+	}
+
+	text := fmt.Sprintf("## %s\n\n", TAKEAWAYS_HEADING)
+	for _, takeaway := range takeaways {
+		text += fmt.Sprintf("- %s\n", strings.TrimSpace(takeaway))
+	}
+
+	sections := splitSections(bw.Content)
+	at := 0
+	if len(sections) > 0 && sections[0].Heading == "" {
+		at = 1
+	}
+
+	sections = append(sections[:at], append([]mdSection{{Heading: TAKEAWAYS_HEADING, Text: text}}, sections[at:]...)...)
+	bw.Content = joinSections(sections)
+	return nil
+}
+
+// appends an FAQ section, which also ends up in the structured data (see findFAQ)
+func (bw *BlogWriter) addFAQ() error {
+	util.Info("Generating FAQ...")
+	var faq []struct {
+		Question string `json:"question"`
+		Answer   string `json:"answer"`
+	}
+	if err := bw.genJSON(prompts.FAQ, `[{"question": "Is this a dry run?", "answer": "Yes."}]`, 1000, &faq); err != nil {
+		return err
+	}
+
+	if len(faq) == 0 {
+		return nil
+	}
+
+	text := fmt.Sprintf("\n## %s\n", FAQ_HEADING)
+	for _, entry := range faq {
+		text += fmt.Sprintf("\n### %s\n\n%s\n", strings.TrimSpace(entry.Question), strings.TrimSpace(entry.Answer))
+	}
+
+	bw.Content = strings.TrimRight(bw.Content, "\n") + "\n" + text
+	return nil
+}
+
+// adds the content modules enabled in the config
+func (bw *BlogWriter) addModules() error {
+	if bw.config.Takeaways {
+		if err := bw.addTakeaways(); err != nil {
+			return fmt.Errorf("Failed to generate key takeaways: %v", err)
+		}
+	}
+
+	if bw.config.FAQ {
+		if err := bw.addFAQ(); err != nil {
+			return fmt.Errorf("Failed to generate FAQ: %v", err)
+		}
+	}
+
+	return nil
+}
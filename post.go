@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	POST_FILE          = "index.md"
+	FRONTMATTER_MARKER = "---"
+)
+
+// an existing post (page bundle) on disk. the front matter is kept as a yaml node
+// so fields we don't touch keep their order and formatting
+type Post struct {
+	Dir         string
+	FrontMatter *yaml.Node // mapping node
+	Body        string
+}
+
+func LoadPost(dir string) (*Post, error) {
+	data, err := os.ReadFile(path.Join(dir, POST_FILE))
+	if err != nil {
+		return nil, err
+	}
+
+	// split the front matter from the body
+	text := string(data)
+	if !strings.HasPrefix(text, FRONTMATTER_MARKER+"\n") {
+		return nil, fmt.Errorf("'%s' has no yaml front matter", path.Join(dir, POST_FILE))
+	}
+
+	end := strings.Index(text[len(FRONTMATTER_MARKER)+1:], "\n"+FRONTMATTER_MARKER+"\n")
+	if end == -1 {
+		return nil, fmt.Errorf("'%s' has unterminated front matter", path.Join(dir, POST_FILE))
+	}
+	end += len(FRONTMATTER_MARKER) + 1
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text[len(FRONTMATTER_MARKER)+1:end]), &doc); err != nil {
+		return nil, fmt.Errorf("Failed to parse front matter of '%s': %v", dir, err)
+	}
+
+	frontMatter := &yaml.Node{Kind: yaml.MappingNode}
+	if len(doc.Content) > 0 && doc.Content[0].Kind == yaml.MappingNode {
+		frontMatter = doc.Content[0]
+	}
+
+	return &Post{
+		Dir:         dir,
+		FrontMatter: frontMatter,
+		Body:        strings.TrimPrefix(text[end+len(FRONTMATTER_MARKER)+2:], "\n"),
+	}, nil
+}
+
+func (p *Post) Slug() string {
+	return path.Base(p.Dir)
+}
+
+func (p *Post) find(key string) *yaml.Node {
+	for i := 0; i+1 < len(p.FrontMatter.Content); i += 2 {
+		if p.FrontMatter.Content[i].Value == key {
+			return p.FrontMatter.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// returns a scalar front matter field, or "" if it isn't set
+func (p *Post) Get(key string) string {
+	if node := p.find(key); node != nil && node.Kind == yaml.ScalarNode {
+		return node.Value
+	}
+
+	return ""
+}
+
+// decodes a front matter field into v, returns false if it isn't set
+func (p *Post) Decode(key string, v interface{}) bool {
+	node := p.find(key)
+	return node != nil && node.Decode(v) == nil
+}
+
+// sets a front matter field, adding it to the end if it doesn't exist yet
+func (p *Post) Set(key string, value interface{}) error {
+	var node yaml.Node
+	if err := node.Encode(value); err != nil {
+		return err
+	}
+
+	// match the front matter we generate: quoted strings and lists on one line
+	styleNode(&node)
+
+	if existing := p.find(key); existing != nil {
+		*existing = node
+		return nil
+	}
+
+	p.FrontMatter.Content = append(p.FrontMatter.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, &node)
+	return nil
+}
+
+// removes a front matter field, if it exists
+func (p *Post) Delete(key string) {
+	for i := 0; i+1 < len(p.FrontMatter.Content); i += 2 {
+		if p.FrontMatter.Content[i].Value == key {
+			p.FrontMatter.Content = append(p.FrontMatter.Content[:i], p.FrontMatter.Content[i+2:]...)
+			return
+		}
+	}
+}
+
+func quoteString(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		node.Style = yaml.DoubleQuotedStyle
+	}
+}
+
+// quotes every string value, and puts lists of scalars on one line
+func styleNode(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		quoteString(node)
+	case yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			styleNode(node.Content[i])
+		}
+	case yaml.SequenceNode:
+		flow := true
+		for _, item := range node.Content {
+			styleNode(item)
+			flow = flow && item.Kind == yaml.ScalarNode
+		}
+		if flow {
+			node.Style = yaml.FlowStyle
+		}
+	}
+}
+
+func (p *Post) Save() error {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(p.FrontMatter); err != nil {
+		return err
+	}
+
+	data := fmt.Sprintf("%s\n%s%s\n\n%s", FRONTMATTER_MARKER, buf.String(), FRONTMATTER_MARKER, p.Body)
+	return os.WriteFile(path.Join(p.Dir, POST_FILE), []byte(data), 0644)
+}
+
+// loads every post in dir, skipping directories that aren't posts
+func LoadPosts(dir string) ([]*Post, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var posts []*Post
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		post, err := LoadPost(path.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+// asks the user questions on the terminal
+type prompter struct {
+	reader *bufio.Reader
+}
+
+func newPrompter() *prompter {
+	return &prompter{reader: bufio.NewReader(os.Stdin)}
+}
+
+// prompts the user, returning def if nothing was entered
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+
+	answer, err := p.reader.ReadString('\n')
+	if err != nil && answer == "" {
+		util.Fail("Failed to read answer: %v", err)
+	}
+
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def
+	}
+	return answer
+}
+
+func (p *prompter) askChoice(question string, choices []string, def string) string {
+	for {
+		answer := p.ask(fmt.Sprintf("%s (%s)", question, strings.Join(choices, "/")), def)
+		for _, choice := range choices {
+			if answer == choice {
+				return answer
+			}
+		}
+		util.Warning("'%s' isn't one of %s", answer, strings.Join(choices, ", "))
+	}
+}
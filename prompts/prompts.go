@@ -0,0 +1,135 @@
+package prompts
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+/*
+	Every prompt sent to the LLM is rendered from a text/template. The defaults are
+	embedded in the binary (see templates/), but any of them can be overridden by
+	placing a file with the same name (eg. 'article.tmpl') in the prompts directory
+	set by the 'prompts' config option.
+*/
+
+const (
+	DEFAULT_LOCALE = "en-US"
+
+	TITLE          = "title"
+	ARTICLE        = "article"
+	IMAGE_META     = "image_meta"
+	TAGS           = "tags"
+	SUMMARY        = "summary"
+	TREND_KEYWORDS = "trend_keywords"
+	SECTION        = "section"
+	REFRESH        = "refresh"
+	SEO            = "seo"
+	TAKEAWAYS      = "takeaways"
+	FAQ            = "faq"
+	IMAGE_SELECT   = "image_select"
+)
+
+var (
+	//go:embed templates/*.tmpl
+	defaults embed.FS
+
+	NAMES = []string{TITLE, ARTICLE, IMAGE_META, TAGS, SUMMARY, TREND_KEYWORDS, SECTION, REFRESH, SEO, TAKEAWAYS, FAQ, IMAGE_SELECT}
+
+	funcs = template.FuncMap{
+		"join":  strings.Join,
+		"lower": strings.ToLower,
+		"upper": strings.ToUpper,
+		"trim":  strings.TrimSpace,
+	}
+
+	overrideDir   string
+	defaultLocale = DEFAULT_LOCALE
+)
+
+// variables available to every template. not every variable is set for every prompt
+type Vars struct {
+	Title          string   // title of the post (article, seo, takeaways, faq)
+	CustomPrompt   string   // the 'custom' config option (title, article)
+	TitleCtx       string   // topic context used to generate the title (title)
+	ArticleCtx     string   // topic context used to write the article (article)
+	BriefCtx       string   // constraints from an editorial brief, if any (article)
+	ThumbnailQuery string   // image prompt used for the thumbnail (article)
+	WordCount      int      // target length of the article (article)
+	Keywords       []string // target keywords from an editorial brief, if any (title, article, tags, seo)
+	Content        string   // the text the prompt is about (image_meta, tags, summary, seo, takeaways, faq)
+	Summary        string   // the summary so far when summarizing in chunks (summary)
+	Trends         []string // trending stories as "title - snippet" (trend_keywords)
+	Section        string   // markdown of the section being rewritten, including its heading (section, refresh)
+	Heading        string   // heading of the section being rewritten, empty for the introduction (section, refresh)
+	Instructions   string   // extra instructions from the editor, if any (section, refresh)
+	Date           string   // when the article was originally written (refresh)
+	ImagePrompt    string   // the image prompt scraped images are judged against (image_select)
+	Candidates     []string // numbered descriptions of the scraped images (image_select)
+	Locale         string   // the 'locale' config option, eg. "en-US" (all)
+}
+
+// sets the directory templates are overridden from and the default locale.
+// an empty dir only uses the embedded defaults
+func Configure(dir, locale string) {
+	overrideDir = dir
+	if locale != "" {
+		defaultLocale = locale
+	}
+}
+
+func load(name string) (*template.Template, error) {
+	fileName := name + ".tmpl"
+
+	var data []byte
+	var err error
+	if overrideDir != "" {
+		data, err = os.ReadFile(path.Join(overrideDir, fileName))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	// no override? use the embedded default
+	if data == nil {
+		if data, err = defaults.ReadFile("templates/" + fileName); err != nil {
+			return nil, fmt.Errorf("Unknown prompt template '%s'", name)
+		}
+	}
+
+	return template.New(fileName).Funcs(funcs).Option("missingkey=error").Parse(string(data))
+}
+
+// renders the named prompt template
+func Render(name string, vars Vars) (string, error) {
+	tmpl, err := load(name)
+	if err != nil {
+		return "", err
+	}
+
+	if vars.Locale == "" {
+		vars.Locale = defaultLocale
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, vars); err != nil {
+		return "", fmt.Errorf("Failed to render prompt template '%s': %v", name, err)
+	}
+
+	// template files end in a newline, which isn't part of the prompt
+	return strings.TrimSuffix(sb.String(), "\n"), nil
+}
+
+// makes sure every template (including overrides) parses and renders
+func Validate() error {
+	for _, name := range NAMES {
+		if _, err := Render(name, Vars{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package prompts
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRenderDefault(t *testing.T) {
+	prompt, err := Render(TITLE, Vars{CustomPrompt: "custom", TitleCtx: "context"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "custom\ncontext\n---\nWrite a short, simple and SEO optimized title for long-tail searches of an article which relates to anything above: "
+	if prompt != expected {
+		t.Fatalf("unexpected prompt:\n%q", prompt)
+	}
+}
+
+func TestRenderOverride(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "tags.tmpl"), []byte("{{join .Keywords \"|\"}} in {{.Locale}}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	Configure(dir, "de-DE")
+	defer Configure("", DEFAULT_LOCALE)
+
+	prompt, err := Render(TAGS, Vars{Keywords: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if prompt != "a|b in de-DE" {
+		t.Fatalf("unexpected prompt: %q", prompt)
+	}
+
+	// templates without an override should still use the default
+	if _, err := Render(SUMMARY, Vars{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/google/subcommands"
+)
+
+type PublishCommand struct {
+	Keep bool
+}
+
+func (*PublishCommand) Name() string     { return "publish" }
+func (*PublishCommand) Synopsis() string { return "Publish a draft" }
+func (p *PublishCommand) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&p.Keep, "keep", false, "don't move the post out of the staging directory")
+}
+
+func (*PublishCommand) Usage() string {
+	return "publish [-keep] <slug>:\n\tClear the draft flag of a post and set its publishDate. Posts in the staging directory are moved to the output directory.\n"
+}
+
+// finds the post with the given slug in the staging or output directory
+func findPost(config *ConfigData, slug string) (*Post, error) {
+	for _, dir := range []string{config.StagingDir, config.OutDir} {
+		if dir == "" {
+			continue
+		}
+
+		if _, err := os.Stat(path.Join(dir, slug, POST_FILE)); err == nil {
+			return LoadPost(path.Join(dir, slug))
+		}
+	}
+
+	return nil, fmt.Errorf("No post with slug '%s' found", slug)
+}
+
+// clears the draft flag and sets the publish date, which the structured data follows
+func (p *PublishCommand) markPublished(config *ConfigData, post *Post) error {
+	if err := post.Set("draft", false); err != nil {
+		return err
+	}
+	if err := post.Set("publishDate", util.GetTimeString()); err != nil {
+		return err
+	}
+
+	if err := NewBlogWriterFromPost(config, post).syncStructuredData(post); err != nil {
+		return err
+	}
+	return post.Save()
+}
+
+func (p *PublishCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	config := ctx.Value("conf").(*ConfigData)
+	if f.NArg() != 1 {
+		fmt.Print(p.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	post, err := findPost(config, f.Arg(0))
+	if err != nil {
+		util.Fail("%v", err)
+	}
+
+	var draft bool
+	if post.Decode("draft", &draft) && !draft {
+		util.Fail("'%s' isn't a draft, it's already published", post.Slug())
+	}
+
+	// move the bundle out of staging first, so a post that can't be moved stays a draft
+	stagedDir := post.Dir
+	if config.StagingDir != "" && path.Dir(post.Dir) == path.Clean(config.StagingDir) && !p.Keep {
+		liveDir := path.Join(config.OutDir, post.Slug())
+		if _, err := os.Stat(liveDir); err == nil {
+			util.Fail("'%s' already exists", liveDir)
+		}
+
+		if err := os.MkdirAll(config.OutDir, 0777); err != nil {
+			util.Fail("Failed to create directory '%s': %v", config.OutDir, err)
+		}
+
+		util.Info("Moving '%s' to '%s'...", post.Dir, liveDir)
+		if err := os.Rename(post.Dir, liveDir); err != nil {
+			util.Fail("Failed to move post: %v", err)
+		}
+		post.Dir = liveDir
+	}
+
+	if err := p.markPublished(config, post); err != nil {
+		// put it back, it's still a draft
+		if post.Dir != stagedDir {
+			if rerr := os.Rename(post.Dir, stagedDir); rerr != nil {
+				util.Warning("Failed to move '%s' back to '%s': %v", post.Dir, stagedDir, rerr)
+			}
+		}
+		util.Fail("Failed to save '%s': %v", post.Dir, err)
+	}
+
+	if post.Dir != stagedDir {
+		moveImageHashes(config, path.Base(post.Dir), path.Dir(stagedDir), path.Dir(post.Dir))
+	}
+
+	util.Success("Published '%s'!", post.Slug())
+	return subcommands.ExitSuccess
+}
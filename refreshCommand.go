@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/trendscraper"
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/google/subcommands"
+)
+
+type RefreshCommand struct {
+	News         bool
+	URLs         urlList
+	Instructions string
+	Report       string
+}
+
+// repeatable -url flag
+type urlList []string
+
+func (u *urlList) String() string { return strings.Join(*u, ",") }
+func (u *urlList) Set(url string) error {
+	*u = append(*u, url)
+	return nil
+}
+
+func (*RefreshCommand) Name() string     { return "refresh" }
+func (*RefreshCommand) Synopsis() string { return "Update outdated facts in an existing post" }
+func (r *RefreshCommand) SetFlags(f *flag.FlagSet) {
+	f.BoolVar(&r.News, "news", false, "pull fresh context from trending news related to the post")
+	f.Var(&r.URLs, "url", "article to pull fresh context from, can be given multiple times")
+	f.StringVar(&r.Instructions, "instructions", "", "extra instructions for the rewrite")
+	f.StringVar(&r.Report, "report", "", "file to write the diff report to (default 'refresh-<slug>.diff')")
+}
+
+func (*RefreshCommand) Usage() string {
+	return `refresh [-news] [-url url]... [-instructions instructions] [-report file] <slug>:
+	Rewrite an existing post so outdated facts are updated, keeping its structure and images.
+	Sets 'lastmod' in the front matter and writes a diff of the changes.
+`
+}
+
+// gathers up to date information for the post from the news and/or the given urls
+func (r *RefreshCommand) freshContext(config *ConfigData, bw *BlogWriter) (string, error) {
+	var context []string
+	if r.News {
+		var tags []string
+		json.Unmarshal([]byte(bw.Tags), &tags)
+		title, article, err := trendscraper.ScrapeRelatedNews(bw.ctx, config.TrendingCategory, config.Locale, append(tags, bw.Title))
+		if err != nil {
+			return "", err
+		}
+		context = append(context, fmt.Sprintf("%s\n%s", title, article))
+	}
+
+	if len(r.URLs) > 0 {
+		summary, err := util.SummarizeArticles(bw.ctx, r.URLs)
+		if err != nil {
+			return "", err
+		}
+		context = append(context, summary)
+	}
+
+	return strings.Join(context, "\n\n"), nil
+}
+
+func (r *RefreshCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	config := ctx.Value("conf").(*ConfigData)
+	if f.NArg() != 1 {
+		fmt.Print(r.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	post, err := findPost(config, f.Arg(0))
+	if err != nil {
+		util.Fail("%v", err)
+	}
+
+	requireValidConfigs([]*ConfigData{config})
+	bw := NewBlogWriterFromPost(config, post)
+	bw.ctx = ctx
+	if bw.ArticleCtx, err = r.freshContext(config, bw); err != nil {
+		util.Fail("Failed to gather fresh context: %v", err)
+	}
+
+	date := post.Get("lastmod")
+	if date == "" {
+		date = post.Get("date")
+	}
+
+	original := bw.Content
+	if err := bw.refreshContent(date, r.Instructions); err != nil {
+		util.Fail("Failed to refresh '%s': %v", post.Slug(), err)
+	}
+
+	// an unchanged post isn't any fresher, so it keeps its lastmod
+	if original == bw.Content {
+		util.Success("Nothing changed in '%s'", post.Slug())
+		return subcommands.ExitSuccess
+	}
+
+	// write the report
+	report := r.Report
+	if report == "" {
+		report = fmt.Sprintf("refresh-%s.diff", post.Slug())
+	}
+
+	if err := os.WriteFile(report, []byte(util.LineDiff(original, bw.Content, 2)+"\n"), 0644); err != nil {
+		util.Fail("Failed to write '%s': %v", report, err)
+	}
+
+	if err := post.Set("lastmod", util.GetTimeString()); err != nil {
+		util.Fail("Failed to update '%s': %v", post.Dir, err)
+	}
+	if err := bw.updatePost(post); err != nil {
+		util.Fail("Failed to update '%s': %v", post.Dir, err)
+	}
+
+	util.Success("Refreshed '%s', changes written to '%s'", post.Slug(), report)
+	return subcommands.ExitSuccess
+}
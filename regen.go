@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/prompts"
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+// builds a blog writer for an existing post, so parts of it can be regenerated
+func NewBlogWriterFromPost(config *ConfigData, post *Post) *BlogWriter {
+	bw := NewBlogWriter(config)
+	bw.outDir = post.Dir
+	bw.Title = post.Get("title")
+	bw.Author = post.Get("author")
+	bw.Thumbnail = post.Get("image")
+	bw.Content = post.Body
+
+	var tags []string
+	post.Decode("tags", &tags)
+	tagString, _ := json.Marshal(tags)
+	bw.Tags = string(tagString)
+
+	var credits []*Credit
+	post.Decode("credits", &credits)
+	for _, credit := range credits {
+		bw.Credits[credit.File] = credit
+	}
+
+	var info ThumbnailInfo
+	if post.Decode("thumbnail", &info) {
+		bw.ThumbnailInfo = &info
+	}
+
+	if post.Get("slug") != "" {
+		bw.SEO = &SEO{
+			Description:  post.Get("description"),
+			FocusKeyword: post.Get("focusKeyword"),
+			Slug:         post.Get("slug"),
+		}
+	}
+
+	// new images shouldn't overwrite existing ones
+	for _, img := range bw.images() {
+		var n int
+		if _, err := fmt.Sscanf(img.File, "file_%d.jpg", &n); err == nil && n > bw.imageCount {
+			bw.imageCount = n
+		}
+	}
+
+	return bw
+}
+
+// writes the regenerated parts back to the post
+func (bw *BlogWriter) updatePost(post *Post) error {
+	var tags []string
+	if err := json.Unmarshal([]byte(bw.Tags), &tags); err != nil {
+		return fmt.Errorf("Bad tags '%s': %v", bw.Tags, err)
+	}
+
+	post.Set("title", bw.Title)
+	post.Set("tags", tags)
+	post.Set("image", bw.Thumbnail)
+	if bw.ThumbnailInfo != nil {
+		post.Set("thumbnail", bw.ThumbnailInfo)
+	}
+
+	bw.renderCredits()
+	if credits := bw.credits(); len(credits) > 0 {
+		post.Set("credits", credits)
+	} else {
+		post.Delete("credits")
+	}
+	post.Body = bw.Content
+
+	if err := bw.syncStructuredData(post); err != nil {
+		return err
+	}
+
+	if err := post.Save(); err != nil {
+		return err
+	}
+
+	bw.recordImageHashes()
+	bw.keepLibraryImages()
+	return nil
+}
+
+// keeps the structured data in sync with the post, dated by its publishDate once published
+func (bw *BlogWriter) syncStructuredData(post *Post) error {
+	published, modified := post.Get("publishDate"), post.Get("lastmod")
+	if published == "" {
+		published = post.Get("date")
+	}
+
+	if bw.config.StructuredData == STRUCTURED_DATA_FRONTMATTER {
+		if err := post.Set(JSONLD_PARAM, bw.structuredData(published, modified)); err != nil {
+			return err
+		}
+	}
+	if err := bw.writeStructuredData(published, modified); err != nil {
+		return fmt.Errorf("Failed to write structured data: %v", err)
+	}
+	return nil
+}
+
+// every image in the post, the thumbnail being first
+func (bw *BlogWriter) images() []mdImage {
+	images := []mdImage{{Alt: bw.ThumbnailQuery, File: bw.Thumbnail}}
+	return append(images, findImages(bw.Content)...)
+}
+
+// rewrites a single '##' section of the content, images in the old section are
+// replaced by newly generated ones
+func (bw *BlogWriter) regenSection(index int, instructions string) error {
+	sections := splitSections(bw.Content)
+	if index < 0 || index >= len(sections) {
+		return fmt.Errorf("Section %d doesn't exist", index+1)
+	}
+	section := sections[index]
+
+	vars := bw.promptVars()
+	vars.Section = section.Text
+	vars.Heading = section.Heading
+	vars.Instructions = instructions
+	prompt, err := prompts.Render(prompts.SECTION, vars)
+	if err != nil {
+		return err
+	}
+
+	util.Info("Regenerating section '%s'...", section.Heading)
+	markdown, err := util.GenerateResponse(util.ResponseOptions{
+		Ctx:       bw.ctx,
+		MaxTokens: 2000,
+		Prompt:    prompt,
+		UseGPT4:   true,
+		Clean:     false,
+		Stub:      section.Text,
+	})
+	if err != nil {
+		return err
+	}
+
+	// make sure the heading survived, otherwise the outline would change
+	markdown = strings.TrimSpace(markdown)
+	if section.Heading != "" && !strings.HasPrefix(markdown, "## ") {
+		markdown = fmt.Sprintf("## %s\n\n%s", section.Heading, markdown)
+	}
+
+	markdown, err = bw.populateImages(markdown)
+	if err != nil {
+		return err
+	}
+
+	// remove the old images which aren't referenced anymore
+	kept := make(map[string]bool)
+	for _, img := range findImages(markdown) {
+		kept[img.File] = true
+	}
+
+	for _, img := range findImages(section.Text) {
+		if !kept[img.File] {
+			bw.removeImage(img.File)
+		}
+	}
+
+	sections[index].Text = markdown + "\n"
+	bw.Content = joinSections(sections)
+	return nil
+}
+
+// regenerates the n'th image (0 being the thumbnail) in place. if prompt is empty the
+// image's original prompt is reused
+func (bw *BlogWriter) regenImage(n int, prompt string) error {
+	images := bw.images()
+	if n < 0 || n >= len(images) {
+		return fmt.Errorf("Image %d doesn't exist", n)
+	}
+	img := images[n]
+
+	if prompt == "" {
+		prompt = img.Alt
+	}
+	// thumbnail prompts aren't kept in existing posts, so make a new one
+	if prompt == "" && n == 0 {
+		var err error
+		if prompt, err = bw.genImageMetaQuery(bw.Title); err != nil {
+			return err
+		}
+	}
+
+	if prompt == "" {
+		return fmt.Errorf("No prompt known for image '%s'", img.File)
+	}
+
+	// posts without a thumbnail get a new file, rather than one named after the directory
+	file := img.File
+	if file == "" {
+		file = bw.getNextFile()
+	}
+
+	if err := bw.genImageAs(prompt, file); err != nil {
+		return err
+	}
+
+	// keep the prompt we used, so the next regeneration starts from it
+	if n == 0 {
+		bw.Thumbnail = file
+		bw.ThumbnailQuery = prompt
+		if err := bw.processThumbnail(); err != nil {
+			return err
+		}
+	} else {
+		bw.Content = setImageAlt(bw.Content, n-1, prompt)
+	}
+	return nil
+}
+
+func (bw *BlogWriter) regenTags() (err error) {
+	bw.Tags, err = bw.genBlogTags()
+	return
+}
+
+// changes the title, moving the output directory to match the new title. posts
+// with an optimized slug keep their directory
+func (bw *BlogWriter) renameTitle(title string) error {
+	if bw.SEO == nil {
+		if err := bw.moveDir(genBlogFilePath(title)); err != nil {
+			return err
+		}
+	}
+
+	bw.Title = title
+	return nil
+}
+
+func (bw *BlogWriter) removeImage(fileName string) {
+	if util.IsDryRun() {
+		return
+	}
+
+	if err := os.Remove(path.Join(bw.outDir, fileName)); err != nil && !os.IsNotExist(err) {
+		util.Warning("Failed to remove '%s': %v", fileName, err)
+	}
+	bw.forgetImage(fileName)
+	bw.releaseLibraryImages(fileName)
+}
+
+// removes everything generated for the post. the output directory is only removed
+// if nothing else is in it
+func (bw *BlogWriter) discard() {
+	for _, img := range bw.images() {
+		bw.removeImage(img.File)
+	}
+	if bw.ThumbnailInfo != nil && bw.ThumbnailInfo.Social != "" {
+		bw.removeImage(bw.ThumbnailInfo.Social)
+	}
+
+	if !util.IsDryRun() {
+		os.Remove(bw.outDir)
+	}
+}
+
+// asks the LLM to update outdated facts in every section, using bw.ArticleCtx as fresh
+// context. sections which lose their heading or images are left as they were
+func (bw *BlogWriter) refreshContent(date, instructions string) error {
+	sections := splitSections(bw.Content)
+	for i, section := range sections {
+		// credits are rendered from the front matter, there's nothing to refresh
+		if strings.TrimSpace(section.Text) == "" || section.Heading == CREDITS_HEADING {
+			continue
+		}
+
+		vars := bw.promptVars()
+		vars.Section = section.Text
+		vars.Heading = section.Heading
+		vars.Date = date
+		vars.Instructions = instructions
+		prompt, err := prompts.Render(prompts.REFRESH, vars)
+		if err != nil {
+			return err
+		}
+
+		util.Info("Refreshing section %d/%d...", i+1, len(sections))
+		markdown, err := util.GenerateResponse(util.ResponseOptions{
+			Ctx:       bw.ctx,
+			MaxTokens: 2000,
+			Prompt:    prompt,
+			UseGPT4:   true,
+			Clean:     false,
+			Stub:      section.Text,
+		})
+		if err != nil {
+			return err
+		}
+		markdown = strings.TrimSpace(markdown)
+
+		// structure and images have to survive
+		if section.Heading != "" && !strings.HasPrefix(markdown, "## "+section.Heading) {
+			util.Warning("Refreshed section '%s' lost its heading, keeping the original", section.Heading)
+			continue
+		}
+
+		if !sameImages(findImages(markdown), findImages(section.Text)) {
+			util.Warning("Refreshed section %d lost its images, keeping the original", i+1)
+			continue
+		}
+
+		sections[i].Text = markdown + "\n"
+	}
+
+	bw.Content = joinSections(sections)
+	return nil
+}
+
+func sameImages(a, b []mdImage) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].File != b[i].File {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/google/subcommands"
+)
+
+type RegenCommand struct {
+	Prompt       string
+	Instructions string
+}
+
+func (*RegenCommand) Name() string     { return "regen" }
+func (*RegenCommand) Synopsis() string { return "Regenerate part of an existing post" }
+func (r *RegenCommand) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&r.Prompt, "prompt", "", "image prompt to use instead of the original one")
+	f.StringVar(&r.Instructions, "instructions", "", "extra instructions for rewriting a section")
+}
+
+func (*RegenCommand) Usage() string {
+	return `regen [-prompt prompt] [-instructions instructions] <part> <slug> [args]:
+	Regenerate part of an existing post in place. Parts:
+	image <slug> <n>          regenerate the n'th image in the article (starting at 1)
+	thumbnail <slug>          regenerate the thumbnail
+	tags <slug>               regenerate the tags
+	section <slug> <heading>  rewrite the '##' section with the given heading
+`
+}
+
+// finds the section with the given heading, ignoring case
+func findSection(content, heading string) (int, error) {
+	for i, section := range splitSections(content) {
+		if strings.EqualFold(section.Heading, strings.TrimSpace(heading)) {
+			return i, nil
+		}
+	}
+
+	return -1, fmt.Errorf("No section with heading '%s'", heading)
+}
+
+func (r *RegenCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
+	config := ctx.Value("conf").(*ConfigData)
+	if f.NArg() < 2 {
+		fmt.Print(r.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	part, slug, args := f.Arg(0), f.Arg(1), f.Args()[2:]
+	post, err := findPost(config, slug)
+	if err != nil {
+		util.Fail("%v", err)
+	}
+
+	requireValidConfigs([]*ConfigData{config})
+	bw := NewBlogWriterFromPost(config, post)
+	bw.ctx = ctx
+	switch {
+	case part == "image" && len(args) == 1:
+		n, convErr := strconv.Atoi(args[0])
+		if convErr != nil || n < 1 {
+			util.Fail("'%s' isn't a valid image number", args[0])
+		}
+		err = bw.regenImage(n, r.Prompt)
+	case part == "thumbnail" && len(args) == 0:
+		err = bw.regenImage(0, r.Prompt)
+	case part == "tags" && len(args) == 0:
+		err = bw.regenTags()
+	case part == "section" && len(args) > 0:
+		var index int
+		if index, err = findSection(bw.Content, strings.Join(args, " ")); err == nil {
+			err = bw.regenSection(index, r.Instructions)
+		}
+	default:
+		fmt.Print(r.Usage())
+		return subcommands.ExitUsageError
+	}
+
+	if err != nil {
+		util.Fail("Failed to regenerate %s: %v", part, err)
+	}
+
+	if err := bw.updatePost(post); err != nil {
+		util.Fail("Failed to update '%s': %v", post.Dir, err)
+	}
+
+	util.Success("Regenerated %s of '%s'!", part, post.Slug())
+	return subcommands.ExitSuccess
+}
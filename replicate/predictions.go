@@ -0,0 +1,290 @@
+package replicate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+	{
+	  "id": "j6t4en2gxjbnvnmxim7ylcyihu",
+	  "model": "stability-ai/sdxl",
+	  "version": "2b017d9b67edd2ee1401238df49d75da53c523f36e363881e057f5dc3ed3c5b2",
+	  "input": {"prompt": "a vision of paradise. unreal engine"},
+	  "output": ["https://replicate.delivery/.../out-0.png"],
+	  "status": "succeeded",
+	  "error": null,
+	  "logs": "...",
+	  "urls": {"get": "...", "cancel": "...", "stream": "..."},
+	  "created_at": "2023-09-08T16:19:34.765994Z",
+	  ...
+	}
+*/
+
+// a prediction of any model. the output's shape depends on the model, see DecodeOutput
+type Prediction struct {
+	ID          string          `json:"id"`
+	Model       string          `json:"model"`
+	Version     string          `json:"version"`
+	Input       json.RawMessage `json:"input"`
+	Output      json.RawMessage `json:"output"`
+	Status      string          `json:"status"`
+	Error       interface{}     `json:"error"` // usually a string, null while the prediction is fine
+	Logs        string          `json:"logs"`
+	URLs        PredictionURLs  `json:"urls"`
+	CreatedAt   string          `json:"created_at"`
+	StartedAt   string          `json:"started_at"`
+	CompletedAt string          `json:"completed_at"`
+}
+
+type PredictionURLs struct {
+	Get    string `json:"get"`
+	Cancel string `json:"cancel"`
+}
+
+// what to run. either Model or Version has to be set
+type PredictionRequest struct {
+	Model   string      // "owner/name", runs the model's latest version (official models only)
+	Version string      // version id, runs that exact version
+	Input   interface{} // anything that marshals to the model's input, eg. a map or struct
+}
+
+type predictionBody struct {
+	Version             string      `json:"version,omitempty"`
+	Input               interface{} `json:"input"`
+	Webhook             string      `json:"webhook,omitempty"`
+	WebhookEventsFilter []string    `json:"webhook_events_filter,omitempty"`
+}
+
+// a page of predictions, see ListPredictions
+type PredictionPage struct {
+	Next     string        `json:"next"` // cursor url of the next page, empty on the last page
+	Previous string        `json:"previous"`
+	Results  []*Prediction `json:"results"`
+}
+
+// true once the prediction succeeded, failed or was canceled
+func (p *Prediction) Done() bool {
+	switch p.Status {
+	case STATUS_SUCCEEDED, STATUS_FAILED, STATUS_CANCELED:
+		return true
+	}
+	return false
+}
+
+// returns a PredictionError if the prediction failed or was canceled
+func (p *Prediction) Err() error {
+	if p.Status != STATUS_FAILED && p.Status != STATUS_CANCELED {
+		return nil
+	}
+
+	message := ""
+	if p.Error != nil {
+		message = fmt.Sprint(p.Error)
+	}
+
+	return &PredictionError{ID: p.ID, Status: p.Status, Message: message, Logs: p.Logs}
+}
+
+// unmarshals the output into v, eg. a []string for image models or a struct for
+// models with structured output. a *interface{} works for any output
+func (p *Prediction) DecodeOutput(v interface{}) error {
+	if len(p.Output) == 0 || string(p.Output) == "null" {
+		return fmt.Errorf("prediction %s has no output", p.ID)
+	}
+
+	if err := json.Unmarshal(p.Output, v); err != nil {
+		return fmt.Errorf("unexpected output of prediction %s: %w", p.ID, err)
+	}
+	return nil
+}
+
+// the output of text models is usually a list of tokens, this joins them
+func (p *Prediction) OutputText() (string, error) {
+	var tokens []string
+	if err := p.DecodeOutput(&tokens); err == nil {
+		return strings.Join(tokens, ""), nil
+	}
+
+	var text string
+	if err := p.DecodeOutput(&text); err != nil {
+		return "", err
+	}
+	return text, nil
+}
+
+// starts a prediction, see https://replicate.com/docs/reference/http#predictions.create
+func (c *ReplicateClient) CreatePrediction(ctx context.Context, req PredictionRequest) (*Prediction, error) {
+	body := predictionBody{Version: req.Version, Input: req.Input}
+	if c.usingWebhook() {
+		body.Webhook = c.WebhookURL
+		body.WebhookEventsFilter = []string{WEBHOOK_EVENT_COMPLETE}
+	}
+
+	endpoint := "/predictions"
+	switch {
+	case req.Version != "":
+	case req.Model != "":
+		owner, name, ok := strings.Cut(req.Model, "/")
+		if !ok {
+			return nil, fmt.Errorf("model '%s' should look like 'owner/name'", req.Model)
+		}
+		endpoint = fmt.Sprintf("/models/%s/%s/predictions", url.PathEscape(owner), url.PathEscape(name))
+	default:
+		return nil, fmt.Errorf("a model or version is required")
+	}
+
+	var prediction Prediction
+	if err := c.do(ctx, "POST", endpoint, body, http.StatusCreated, &prediction); err != nil {
+		return nil, fmt.Errorf("failed to create prediction: %w", err)
+	}
+
+	return &prediction, nil
+}
+
+func (c *ReplicateClient) GetPrediction(ctx context.Context, id string) (*Prediction, error) {
+	var prediction Prediction
+	if err := c.do(ctx, "GET", "/predictions/"+url.PathEscape(id), nil, http.StatusOK, &prediction); err != nil {
+		return nil, fmt.Errorf("failed to get prediction: %w", err)
+	}
+
+	return &prediction, nil
+}
+
+// lists the account's predictions, newest first. pass the previous page's Next as the
+// cursor to continue, or "" for the first page
+func (c *ReplicateClient) ListPredictions(ctx context.Context, cursor string) (*PredictionPage, error) {
+	endpoint := "/predictions"
+	if cursor != "" {
+		if !strings.HasPrefix(cursor, c.BaseURL) {
+			return nil, fmt.Errorf("cursor '%s' isn't a url of this api", cursor)
+		}
+		endpoint = strings.TrimPrefix(cursor, c.BaseURL)
+	}
+
+	var page PredictionPage
+	if err := c.do(ctx, "GET", endpoint, nil, http.StatusOK, &page); err != nil {
+		return nil, fmt.Errorf("failed to list predictions: %w", err)
+	}
+
+	return &page, nil
+}
+
+// see https://replicate.com/docs/reference/http#predictions.cancel
+func (c *ReplicateClient) Cancel(ctx context.Context, id string) error {
+	return c.do(ctx, "POST", "/predictions/"+url.PathEscape(id)+"/cancel", nil, http.StatusOK, nil)
+}
+
+// cancels the prediction after ctx is done, so it doesn't keep running (and costing money).
+// returns ctx's error
+func (c *ReplicateClient) cancelAfter(ctx context.Context, id string) error {
+	cancelCtx, done := context.WithTimeout(context.Background(), 10*time.Second)
+	defer done()
+
+	if err := c.Cancel(cancelCtx, id); err != nil {
+		return fmt.Errorf("%v (and failed to cancel prediction %s: %v)", ctx.Err(), id, err)
+	}
+	return ctx.Err()
+}
+
+// waits for the prediction to finish, through the webhook if one is set up and by
+// polling otherwise. onPoll (if not nil) is called with every update. if ctx is done
+// first, the prediction is canceled
+func (c *ReplicateClient) wait(ctx context.Context, prediction *Prediction, onPoll func(*Prediction)) (*Prediction, error) {
+	interval := c.PollInterval
+	var delivered <-chan *Prediction
+	if c.usingWebhook() {
+		delivered = c.Webhook.wait(prediction.ID)
+		defer c.Webhook.forget(prediction.ID)
+		interval = c.WebhookPollInterval
+	}
+
+	for i := 0; i < MAX_POLL_ATTEMPTS; i++ {
+		latest, err := c.GetPrediction(ctx, prediction.ID)
+		if ctx.Err() != nil {
+			return nil, c.cancelAfter(ctx, prediction.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if onPoll != nil {
+			onPoll(latest)
+		}
+		if latest.Done() {
+			return latest, latest.Err()
+		}
+
+		// still starting or processing, wait for the webhook or back off
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, c.cancelAfter(ctx, prediction.ID)
+		case latest := <-delivered:
+			timer.Stop()
+			if onPoll != nil {
+				onPoll(latest)
+			}
+			if latest.Done() {
+				return latest, latest.Err()
+			}
+		case <-timer.C:
+		}
+
+		// the webhook fallback polls at a steady pace
+		if c.usingWebhook() {
+			continue
+		}
+		if interval *= 2; interval > c.MaxPollInterval {
+			interval = c.MaxPollInterval
+		}
+	}
+
+	return nil, fmt.Errorf("prediction timed out!!")
+}
+
+// waits for the prediction to finish. returns a PredictionError if it failed or was
+// canceled. if ctx is done first, the prediction is canceled
+func (c *ReplicateClient) Wait(ctx context.Context, prediction *Prediction) (*Prediction, error) {
+	return c.wait(ctx, prediction, nil)
+}
+
+// like Wait, but calls onLog with each new chunk of the prediction's logs. the logs are
+// picked up whenever the prediction is polled (or its webhook arrives)
+func (c *ReplicateClient) WaitWithLogs(ctx context.Context, prediction *Prediction, onLog func(string)) (*Prediction, error) {
+	seen := 0
+	return c.wait(ctx, prediction, func(latest *Prediction) {
+		// logs only grow, but don't trust that blindly
+		if len(latest.Logs) < seen {
+			seen = 0
+		}
+
+		if len(latest.Logs) > seen {
+			onLog(latest.Logs[seen:])
+			seen = len(latest.Logs)
+		}
+	})
+}
+
+// creates a prediction, waits for it and decodes its output into out (if not nil)
+func (c *ReplicateClient) Run(ctx context.Context, req PredictionRequest, out interface{}) (*Prediction, error) {
+	prediction, err := c.CreatePrediction(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if prediction, err = c.Wait(ctx, prediction); err != nil {
+		return prediction, err
+	}
+
+	if out != nil {
+		return prediction, prediction.DecodeOutput(out)
+	}
+	return prediction, nil
+}
@@ -2,28 +2,69 @@ package replicate
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type ReplicateClient struct {
-	APIKey string
-	ID     string
-	Header http.Header
+	APIKey     string
+	ID         string      // id of the last prediction
+	Header     http.Header // sent with every request, never modified by the client
+	BaseURL    string      // DEFAULT_BASE_URL, or a stand-in when testing
+	HTTPClient *http.Client
+
+	PollInterval    time.Duration // first delay between polls, doubled after each poll
+	MaxPollInterval time.Duration
+
+	// if both are set, replicate calls WebhookURL when a prediction completes and Webhook
+	// (which should be served at that url) hands it back. polling every
+	// WebhookPollInterval is kept as a fallback in case the webhook never arrives
+	WebhookURL          string
+	Webhook             *WebhookReceiver
+	WebhookPollInterval time.Duration
 }
 
+const (
+	DEFAULT_BASE_URL          = "https://api.replicate.com/v1"
+	DEFAULT_POLL_INTERVAL     = time.Second
+	DEFAULT_MAX_POLL_INTERVAL = 10 * time.Second
+	DEFAULT_WEBHOOK_POLL      = 30 * time.Second
+	MAX_POLL_ATTEMPTS         = 50
+	MAX_REQUEST_RETRIES       = 5           // retries of rate limited requests, and failed (5xx) GETs
+	RETRY_INTERVAL            = time.Second // first delay between retries without a Retry-After, doubled after each retry
+	ERROR_LOG_LINES           = 5           // lines of the prediction logs included in errors
+
+	// prediction statuses, see https://replicate.com/docs/reference/http#predictions.get
+	STATUS_STARTING   = "starting"
+	STATUS_PROCESSING = "processing"
+	STATUS_SUCCEEDED  = "succeeded"
+	STATUS_FAILED     = "failed"
+	STATUS_CANCELED   = "canceled"
+)
+
 func NewClient(apiKey string) *ReplicateClient {
 	c := &ReplicateClient{
-		APIKey: apiKey,
-		Header: make(http.Header),
+		APIKey:          apiKey,
+		Header:          make(http.Header),
+		BaseURL:         DEFAULT_BASE_URL,
+		HTTPClient:      http.DefaultClient,
+		PollInterval:    DEFAULT_POLL_INTERVAL,
+		MaxPollInterval: DEFAULT_MAX_POLL_INTERVAL,
+
+		WebhookPollInterval: DEFAULT_WEBHOOK_POLL,
 	}
 
 	c.Header.Set("Authorization", "Token "+apiKey)
 	return c
 }
 
+// input of the sdxl model used by MakePrediction, https://replicate.com/stability-ai/sdxl/api
 type ReplicatePredictionInput struct {
 	Prompt         string `json:"prompt"`
 	NegativePrompt string `json:"negative_prompt"`
@@ -32,155 +73,185 @@ type ReplicatePredictionInput struct {
 	Num_Outputs    int    `json:"num_outputs"`
 }
 
-type ReplicatePredictionBody struct {
-	Version string                   `json:"version"`
-	Input   ReplicatePredictionInput `json:"input"`
-}
-
-type ReplicatePredictionResponse struct {
-	Error string `json:"error"`
-	ID    string `json:"id"`
+// returned when a prediction fails or is canceled
+type PredictionError struct {
+	ID      string
+	Status  string
+	Message string // replicate's error, if any
+	Logs    string // the prediction's full logs
 }
 
-const (
-	DEFAULT_NEGATIVE_PROMPT = "((((ugly)))), (((duplicate))), ((morbid)), ((mutilated)), [out of frame], extra fingers, mutated hands, ((poorly drawn hands)), ((poorly drawn face)), (((mutation))), (((deformed))), blurry, ((bad anatomy)), (((bad proportions))), ((extra limbs)), cloned face, (((disfigured))), gross proportions, (malformed limbs), ((missing arms)), ((missing legs)), (((extra arms))), (((extra legs))), (fused fingers), (too many fingers), (((long neck))), ((poster)), ((meme))"
-)
-
-// using this model to generate Images: https://replicate.com/stability-ai/sdxl/api
-func (c *ReplicateClient) sendImagePrompt(prompt string) error {
-	/*
-		curl -s -X POST \
-		-d '{"version": "2b017d9b67edd2ee1401238df49d75da53c523f36e363881e057f5dc3ed3c5b2", "input": {"prompt": "a vision of paradise. unreal engine"}}' \
-		-H "Authorization: Token $REPLICATE_API_TOKEN" \
-		"https://api.replicate.com/v1/predictions"
-	*/
-
-	// create body
-	body := ReplicatePredictionBody{
-		Version: "2b017d9b67edd2ee1401238df49d75da53c523f36e363881e057f5dc3ed3c5b2",
-		Input: ReplicatePredictionInput{
-			Prompt:         prompt,
-			NegativePrompt: DEFAULT_NEGATIVE_PROMPT,
-			Width:          960,
-			Height:         640,
-			Num_Outputs:    1,
-		},
-	}
-
-	// marshal body
-	payloadBytes, err := json.Marshal(body)
-	if err != nil {
-		return err
+func (e *PredictionError) Error() string {
+	msg := fmt.Sprintf("prediction %s %s", e.ID, e.Status)
+	if e.Message != "" {
+		msg += ": " + e.Message
 	}
 
-	// create request
-	req, err := http.NewRequest("POST", "https://api.replicate.com/v1/predictions", bytes.NewBuffer(payloadBytes))
-	if err != nil {
-		return err
+	// the end of the logs usually explains what went wrong
+	if logs := strings.TrimSpace(e.Logs); logs != "" {
+		lines := strings.Split(logs, "\n")
+		if len(lines) > ERROR_LOG_LINES {
+			lines = lines[len(lines)-ERROR_LOG_LINES:]
+		}
+		msg += "\nlogs:\n" + strings.Join(lines, "\n")
 	}
 
-	req.Header = c.Header
-	req.Header.Set("Content-Type", "application/json")
+	return msg
+}
 
-	// make request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
+// returned for unexpected status codes
+type APIError struct {
+	StatusCode int
+	Detail     string // replicate's explanation, if it gave one
+}
 
-	if resp.StatusCode != 201 {
-		return fmt.Errorf("failed to send prompt: %d", resp.StatusCode)
+func (e *APIError) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("replicate returned %d: %s", e.StatusCode, e.Detail)
 	}
+	return fmt.Sprintf("replicate returned %d", e.StatusCode)
+}
 
-	// decode response
-	var predictionResponse ReplicatePredictionResponse
-	dec := json.NewDecoder(resp.Body)
-	err = dec.Decode(&predictionResponse)
-	if err != nil {
-		return err
+// how long to wait before retrying a response, honoring Retry-After (in seconds or as a date)
+func retryDelay(resp *http.Response, backoff time.Duration) time.Duration {
+	retryAfter := resp.Header.Get("Retry-After")
+	if secs, err := strconv.Atoi(retryAfter); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
 	}
 
-	// check for error
-	if predictionResponse.Error != "" {
-		return fmt.Errorf("replicate error: %s", predictionResponse.Error)
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+		return 0
 	}
 
-	// set id
-	c.ID = predictionResponse.ID
-	return nil
+	return backoff
 }
 
-/*
-	{
-	  "id": "j6t4en2gxjbnvnmxim7ylcyihu",
-	  "input": {"prompt": "a vision of paradise. unreal engine"},
-	  "output": "...",
-	  "status": "succeeded"
+// waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
 	}
-*/
-type ReplicatePredictionStatusResponse struct {
-	ID     string                   `json:"id"`
-	Input  ReplicatePredictionInput `json:"input"`
-	Output []string                 `json:"output"`
-	Status string                   `json:"status"`
 }
 
-const (
-	MAX_POLL_ATTEMPTS = 50
-)
-
-// returns url of generated image
-func (c *ReplicateClient) waitForPredictionFinished() (string, error) {
-	/* curl -s -H "Authorization: Token $REPLICATE_API_TOKEN" \
-	"https://api.replicate.com/v1/predictions/j6t4en2gxjbnvnmxim7ylcyihu" */
-	req, err := http.NewRequest("GET", "https://api.replicate.com/v1/predictions/"+c.ID, nil)
-	if err != nil {
-		return "", err
+// sends a request to the api and decodes the json response into out (if not nil).
+// rate limited (429) requests are retried with exponential backoff, and so are failed
+// (5xx) GETs. a failed POST might have been carried out anyway, eg. starting a paid
+// prediction, so it isn't sent again
+func (c *ReplicateClient) do(ctx context.Context, method, endpoint string, body interface{}, expected int, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
 	}
 
-	req.Header = c.Header
-
-	for i := 0; i < MAX_POLL_ATTEMPTS; i++ {
-		// make request
-		resp, err := http.DefaultClient.Do(req)
+	backoff := RETRY_INTERVAL
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+endpoint, bytes.NewReader(payload))
 		if err != nil {
-			return "", err
+			return err
 		}
 
-		if resp.StatusCode != 200 {
-			continue
+		// the caller's header is shared (eg. for downloading the output), so copy it
+		req.Header = c.Header.Clone()
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
 		}
 
-		// decode response
-		var predictionResponse ReplicatePredictionStatusResponse
-		dec := json.NewDecoder(resp.Body)
-		err = dec.Decode(&predictionResponse)
+		resp, err := c.HTTPClient.Do(req)
 		if err != nil {
-			return "", err
+			return err
 		}
 
-		// check if output succeeded
-		if predictionResponse.Status == "succeeded" {
-			if len(predictionResponse.Output) == 0 {
-				return "", fmt.Errorf("prediction succeeded but no output found")
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+
+		retryable := resp.StatusCode == http.StatusTooManyRequests || (resp.StatusCode >= 500 && method == "GET")
+		if retryable && attempt < MAX_REQUEST_RETRIES {
+			if err := sleep(ctx, retryDelay(resp, backoff)); err != nil {
+				return err
 			}
+			backoff *= 2
+			continue
+		}
 
-			return predictionResponse.Output[0], nil
+		if resp.StatusCode != expected {
+			var detail struct {
+				Detail string `json:"detail"`
+			}
+			json.Unmarshal(data, &detail)
+			return &APIError{StatusCode: resp.StatusCode, Detail: detail.Detail}
 		}
 
-		// sleep for 2 seconds
-		time.Sleep(time.Second * 2)
+		if out == nil {
+			return nil
+		}
+		return json.Unmarshal(data, out)
 	}
+}
+
+const (
+	SDXL_VERSION            = "2b017d9b67edd2ee1401238df49d75da53c523f36e363881e057f5dc3ed3c5b2"
+	DEFAULT_NEGATIVE_PROMPT = "((((ugly)))), (((duplicate))), ((morbid)), ((mutilated)), [out of frame], extra fingers, mutated hands, ((poorly drawn hands)), ((poorly drawn face)), (((mutation))), (((deformed))), blurry, ((bad anatomy)), (((bad proportions))), ((extra limbs)), cloned face, (((disfigured))), gross proportions, (malformed limbs), ((missing arms)), ((missing legs)), (((extra arms))), (((extra legs))), (fused fingers), (too many fingers), (((long neck))), ((poster)), ((meme))"
+)
+
+func (c *ReplicateClient) usingWebhook() bool {
+	return c.WebhookURL != "" && c.Webhook != nil
+}
 
-	return "", fmt.Errorf("prediction timed out!!")
+// cancels the last prediction started by MakePrediction
+func (c *ReplicateClient) CancelPrediction(ctx context.Context) error {
+	return c.Cancel(ctx, c.ID)
 }
 
 func (c *ReplicateClient) MakePrediction(prompt string) (string, error) {
-	err := c.sendImagePrompt(prompt)
+	return c.MakePredictionContext(context.Background(), prompt)
+}
+
+// same as MakePrediction, but the prediction is canceled if ctx is done before it finishes
+func (c *ReplicateClient) MakePredictionContext(ctx context.Context, prompt string) (string, error) {
+	/*
+		curl -s -X POST \
+		-d '{"version": "2b017d9b67edd2ee1401238df49d75da53c523f36e363881e057f5dc3ed3c5b2", "input": {"prompt": "a vision of paradise. unreal engine"}}' \
+		-H "Authorization: Token $REPLICATE_API_TOKEN" \
+		"https://api.replicate.com/v1/predictions"
+	*/
+	prediction, err := c.CreatePrediction(ctx, PredictionRequest{
+		Version: SDXL_VERSION,
+		Input: ReplicatePredictionInput{
+			Prompt:         prompt,
+			NegativePrompt: DEFAULT_NEGATIVE_PROMPT,
+			Width:          960,
+			Height:         640,
+			Num_Outputs:    1,
+		},
+	})
 	if err != nil {
 		return "", err
 	}
 
-	return c.waitForPredictionFinished()
+	// set id
+	c.ID = prediction.ID
+	if prediction, err = c.Wait(ctx, prediction); err != nil {
+		return "", err
+	}
+
+	var output []string
+	if err := prediction.DecodeOutput(&output); err != nil || len(output) == 0 {
+		return "", fmt.Errorf("prediction succeeded but no output found")
+	}
+	return output[0], nil
 }
@@ -1,9 +1,22 @@
 package replicate
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestGenerateImage(t *testing.T) {
@@ -16,3 +29,428 @@ func TestGenerateImage(t *testing.T) {
 
 	fmt.Println(url)
 }
+
+// a stand-in for the replicate api. predictions go through statuses in order, the
+// last status is repeated
+type fakeReplicate struct {
+	statuses    []string
+	polls       int
+	rateLimited int // how many requests get a 429 before succeeding
+	unavailable int // how many requests get a 503 before succeeding
+	requests    int
+	canceled    bool
+	output      interface{}
+	errorMsg    string
+	logs        string
+	logLines    []string // if set, each poll returns one more line of logs
+	secret      string   // webhooks are signed with this, if the prediction asks for one
+	created     string   // path the prediction was created at
+	input       map[string]interface{}
+}
+
+// signs a webhook like replicate does
+func signWebhook(secret, id string, timestamp int64, body []byte) http.Header {
+	key, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, WEBHOOK_SECRET_PREFIX))
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%d.%s", id, timestamp, body)
+
+	header := make(http.Header)
+	header.Set("webhook-id", id)
+	header.Set("webhook-timestamp", strconv.FormatInt(timestamp, 10))
+	header.Set("webhook-signature", "v1,bm9wZQ== v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return header
+}
+
+func (f *fakeReplicate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Token key" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	f.requests++
+	if f.unavailable > 0 {
+		f.unavailable--
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if f.rateLimited > 0 {
+		f.rateLimited--
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && (r.URL.Path == "/predictions" || strings.HasPrefix(r.URL.Path, "/models/")):
+		if r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body struct {
+			predictionBody
+			Input map[string]interface{} `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.created, f.input = r.URL.Path, body.Input
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "abc", "status": STATUS_STARTING})
+
+		// complete the prediction through the webhook
+		if body.Webhook != "" {
+			go func() {
+				payload, _ := json.Marshal(map[string]interface{}{"id": "abc", "status": STATUS_SUCCEEDED, "output": f.output})
+				req, _ := http.NewRequest("POST", body.Webhook, bytes.NewReader(payload))
+				req.Header = signWebhook(f.secret, "msg_1", time.Now().Unix(), payload)
+				if resp, err := http.DefaultClient.Do(req); err == nil {
+					resp.Body.Close()
+				}
+			}()
+		}
+	case r.Method == "GET" && r.URL.Path == "/predictions/abc":
+		status := f.statuses[len(f.statuses)-1]
+		if f.polls < len(f.statuses) {
+			status = f.statuses[f.polls]
+		}
+		f.polls++
+
+		logs := f.logs
+		if f.logLines != nil {
+			n := f.polls
+			if n > len(f.logLines) {
+				n = len(f.logLines)
+			}
+			logs = strings.Join(f.logLines[:n], "")
+		}
+
+		resp := map[string]interface{}{"id": "abc", "status": status, "logs": logs}
+		if status == STATUS_SUCCEEDED {
+			resp["output"] = f.output
+		}
+		if status == STATUS_FAILED {
+			resp["error"] = f.errorMsg
+		}
+		json.NewEncoder(w).Encode(resp)
+	case r.Method == "GET" && r.URL.Path == "/predictions":
+		page := map[string]interface{}{"results": []map[string]interface{}{{"id": "abc", "status": STATUS_SUCCEEDED}}}
+		if r.URL.Query().Get("cursor") == "" {
+			page["next"] = "http://" + r.Host + "/predictions?cursor=2"
+		} else {
+			page["results"] = []map[string]interface{}{{"id": "def", "status": STATUS_FAILED}}
+		}
+		json.NewEncoder(w).Encode(page)
+	case r.Method == "POST" && r.URL.Path == "/predictions/abc/cancel":
+		f.canceled = true
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": "abc", "status": STATUS_CANCELED})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestClient(t *testing.T, fake *fakeReplicate) *ReplicateClient {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	client := NewClient("key")
+	client.BaseURL = server.URL
+	client.HTTPClient = server.Client()
+	client.PollInterval = time.Millisecond
+	client.MaxPollInterval = 5 * time.Millisecond
+	return client
+}
+
+func TestPredictionSucceeded(t *testing.T) {
+	fake := &fakeReplicate{
+		statuses:    []string{STATUS_STARTING, STATUS_PROCESSING, STATUS_SUCCEEDED},
+		rateLimited: 1,
+		output:      []string{"https://example.com/out.png"},
+	}
+	client := newTestClient(t, fake)
+
+	url, err := client.MakePrediction("a vision of paradise")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url != "https://example.com/out.png" || fake.polls != 3 {
+		t.Fatalf("unexpected url '%s' after %d polls", url, fake.polls)
+	}
+
+	// the header is shared with the image download, it shouldn't pick up a content type
+	if client.Header.Get("Content-Type") != "" {
+		t.Fatal("client header was modified")
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	fake := &fakeReplicate{
+		statuses:    []string{STATUS_SUCCEEDED},
+		unavailable: 1,
+		output:      []string{"https://example.com/out.png"},
+	}
+	client := newTestClient(t, fake)
+
+	// the prediction might have been started anyway, so it isn't created twice
+	_, err := client.MakePrediction("a vision of paradise")
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected a 503, got %v", err)
+	}
+	if fake.requests != 1 {
+		t.Fatalf("sent %d requests", fake.requests)
+	}
+
+	// reading is safe to retry
+	fake.unavailable = 1
+	prediction, err := client.GetPrediction(context.Background(), "abc")
+	if err != nil || prediction.Status != STATUS_SUCCEEDED {
+		t.Fatalf("unexpected prediction %v: %v", prediction, err)
+	}
+}
+
+func TestPredictionFailed(t *testing.T) {
+	fake := &fakeReplicate{
+		statuses: []string{STATUS_PROCESSING, STATUS_FAILED},
+		errorMsg: "CUDA out of memory",
+		logs:     "loading model\nstarting\nout of memory",
+	}
+	client := newTestClient(t, fake)
+
+	_, err := client.MakePrediction("a vision of paradise")
+	predictionErr, ok := err.(*PredictionError)
+	if !ok {
+		t.Fatalf("expected a prediction error, got %v", err)
+	}
+
+	if predictionErr.Status != STATUS_FAILED || predictionErr.Message != "CUDA out of memory" || !strings.Contains(err.Error(), "out of memory") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// failed predictions shouldn't be polled until MAX_POLL_ATTEMPTS
+	if fake.polls != 2 {
+		t.Fatalf("polled %d times", fake.polls)
+	}
+}
+
+func TestPredictionCanceled(t *testing.T) {
+	fake := &fakeReplicate{statuses: []string{STATUS_PROCESSING}}
+	client := newTestClient(t, fake)
+	client.PollInterval = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := client.MakePredictionContext(ctx, "a vision of paradise"); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+
+	if !fake.canceled {
+		t.Fatal("prediction wasn't canceled")
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	client := newTestClient(t, &fakeReplicate{})
+	client.Header.Set("Authorization", "Token wrong")
+
+	_, err := client.MakePrediction("a vision of paradise")
+	if apiErr, ok := errors.Unwrap(err).(*APIError); !ok || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a 401, got %v", err)
+	}
+}
+
+const TEST_WEBHOOK_SECRET = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+
+func TestWebhookCompletion(t *testing.T) {
+	fake := &fakeReplicate{
+		statuses: []string{STATUS_PROCESSING},
+		output:   []string{"https://example.com/out.png"},
+		secret:   TEST_WEBHOOK_SECRET,
+	}
+	client := newTestClient(t, fake)
+
+	receiver := NewWebhookReceiver(TEST_WEBHOOK_SECRET)
+	webhookServer := httptest.NewServer(receiver)
+	defer webhookServer.Close()
+
+	client.WebhookURL = webhookServer.URL
+	client.Webhook = receiver
+	client.WebhookPollInterval = time.Hour // only the webhook can finish the prediction
+
+	url, err := client.MakePrediction("a vision of paradise")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if url != "https://example.com/out.png" {
+		t.Fatalf("unexpected url '%s'", url)
+	}
+}
+
+func TestWebhookVerify(t *testing.T) {
+	receiver := NewWebhookReceiver(TEST_WEBHOOK_SECRET)
+	body := []byte(`{"id": "abc", "status": "succeeded"}`)
+	now := time.Now()
+
+	if err := receiver.Verify(signWebhook(TEST_WEBHOOK_SECRET, "msg_1", now.Unix(), body), body, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// tampered body
+	if err := receiver.Verify(signWebhook(TEST_WEBHOOK_SECRET, "msg_1", now.Unix(), body), []byte(`{"id": "abc"}`), now); err == nil {
+		t.Fatal("accepted a tampered body")
+	}
+
+	// wrong secret
+	if err := receiver.Verify(signWebhook("whsec_c2VjcmV0", "msg_1", now.Unix(), body), body, now); err == nil {
+		t.Fatal("accepted the wrong secret")
+	}
+
+	// replayed
+	old := now.Add(-time.Hour)
+	if err := receiver.Verify(signWebhook(TEST_WEBHOOK_SECRET, "msg_1", old.Unix(), body), body, now); err == nil {
+		t.Fatal("accepted an old webhook")
+	}
+
+	// unsigned webhooks are rejected by the handler
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, httptest.NewRequest("POST", "/", bytes.NewReader(body)))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unsigned webhook got %d", rec.Code)
+	}
+}
+
+func TestWebhookReceiver(t *testing.T) {
+	receiver := NewWebhookReceiver(TEST_WEBHOOK_SECRET)
+	now := time.Now()
+
+	// webhooks nobody waits for are dropped after a while
+	receiver.deliver(&Prediction{ID: "old"}, now)
+	receiver.deliver(&Prediction{ID: "new"}, now.Add(WEBHOOK_EARLY_TTL+time.Second))
+	if _, ok := receiver.early["old"]; ok || len(receiver.early) != 1 {
+		t.Fatalf("kept %d early webhooks", len(receiver.early))
+	}
+	if prediction := <-receiver.wait("new"); prediction.ID != "new" {
+		t.Fatalf("got prediction '%s'", prediction.ID)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- receiver.Serve(ctx, listener) }()
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("receiver kept serving")
+	}
+}
+
+func TestRunModel(t *testing.T) {
+	fake := &fakeReplicate{
+		statuses: []string{STATUS_PROCESSING, STATUS_SUCCEEDED},
+		output:   map[string]interface{}{"caption": "a dog on a beach", "score": 0.9},
+	}
+	client := newTestClient(t, fake)
+
+	var output struct {
+		Caption string  `json:"caption"`
+		Score   float64 `json:"score"`
+	}
+	prediction, err := client.Run(context.Background(), PredictionRequest{
+		Model: "acme/captioner",
+		Input: map[string]interface{}{"image": "https://example.com/dog.jpg"},
+	}, &output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fake.created != "/models/acme/captioner/predictions" || fake.input["image"] != "https://example.com/dog.jpg" {
+		t.Fatalf("created at '%s' with %v", fake.created, fake.input)
+	}
+
+	if output.Caption != "a dog on a beach" || output.Score != 0.9 || prediction.Status != STATUS_SUCCEEDED {
+		t.Fatalf("unexpected output %+v", output)
+	}
+
+	// untyped
+	var untyped interface{}
+	if err := prediction.DecodeOutput(&untyped); err != nil || untyped.(map[string]interface{})["caption"] != "a dog on a beach" {
+		t.Fatalf("unexpected untyped output %v: %v", untyped, err)
+	}
+
+	if _, err := client.CreatePrediction(context.Background(), PredictionRequest{Model: "nope"}); err == nil {
+		t.Fatal("accepted a model without an owner")
+	}
+}
+
+func TestOutputText(t *testing.T) {
+	fake := &fakeReplicate{statuses: []string{STATUS_SUCCEEDED}, output: []string{"Hello", ",", " world"}}
+	client := newTestClient(t, fake)
+
+	prediction, err := client.Run(context.Background(), PredictionRequest{Version: "v1", Input: map[string]string{"prompt": "hi"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if text, err := prediction.OutputText(); err != nil || text != "Hello, world" {
+		t.Fatalf("unexpected text '%s': %v", text, err)
+	}
+}
+
+func TestListPredictions(t *testing.T) {
+	client := newTestClient(t, &fakeReplicate{statuses: []string{STATUS_SUCCEEDED}})
+
+	page, err := client.ListPredictions(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != "abc" || page.Next == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	if page, err = client.ListPredictions(context.Background(), page.Next); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Results) != 1 || page.Results[0].ID != "def" || page.Next != "" {
+		t.Fatalf("unexpected second page %+v", page)
+	}
+
+	prediction, err := client.GetPrediction(context.Background(), "abc")
+	if err != nil || prediction.ID != "abc" || !prediction.Done() {
+		t.Fatalf("unexpected prediction %+v", prediction)
+	}
+}
+
+func TestWaitWithLogs(t *testing.T) {
+	fake := &fakeReplicate{
+		statuses: []string{STATUS_STARTING, STATUS_PROCESSING, STATUS_PROCESSING, STATUS_SUCCEEDED},
+		logLines: []string{"loading\n", "step 1\n", "step 2\n", "done\n"},
+		output:   []string{"https://example.com/out.png"},
+	}
+	client := newTestClient(t, fake)
+
+	prediction, err := client.CreatePrediction(context.Background(), PredictionRequest{Version: SDXL_VERSION, Input: map[string]string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var chunks []string
+	if _, err := client.WaitWithLogs(context.Background(), prediction, func(chunk string) {
+		chunks = append(chunks, chunk)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Join(chunks, "") != "loading\nstep 1\nstep 2\ndone\n" || len(chunks) != 4 {
+		t.Fatalf("unexpected log chunks %q", chunks)
+	}
+}
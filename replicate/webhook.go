@@ -0,0 +1,204 @@
+package replicate
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WEBHOOK_SECRET_PREFIX  = "whsec_"
+	WEBHOOK_TOLERANCE      = 5 * time.Minute // max age of a webhook, to stop replays
+	WEBHOOK_MAX_BODY       = 1 << 20
+	WEBHOOK_EVENT_COMPLETE = "completed"
+	WEBHOOK_EARLY_TTL      = 10 * time.Minute // how long webhooks nobody waits for are kept
+)
+
+/*
+	Replicate signs webhooks the same way Svix does, see
+	https://replicate.com/docs/topics/webhooks/verify-webhook:
+
+	webhook-id: msg_p5jXN8AQM9LWM0D4loKWxJek
+	webhook-timestamp: 1674087231
+	webhook-signature: v1,K5oZfzN95Z9UVu1EsfQmfVNQhnkZ2pj9o9NDN/H/pI4= v1,...
+
+	the signature is the base64 HMAC-SHA256 of "<id>.<timestamp>.<body>", keyed with
+	the base64 decoded part of the 'whsec_...' secret after the prefix
+*/
+
+// receives prediction webhooks and hands them to whoever is waiting for that prediction.
+// serve it with Serve, http.Serve or any mux
+type WebhookReceiver struct {
+	Secret string // "whsec_...", see ReplicateClient.GetWebhookSecret
+
+	mu      sync.Mutex
+	waiting map[string]chan *Prediction
+	early   map[string]earlyWebhook // completed before anyone waited
+}
+
+// a webhook which arrived before anyone waited for its prediction. it might never be
+// waited for, eg. a retried delivery or a prediction started elsewhere
+type earlyWebhook struct {
+	Prediction *Prediction
+	Received   time.Time
+}
+
+func NewWebhookReceiver(secret string) *WebhookReceiver {
+	return &WebhookReceiver{
+		Secret:  secret,
+		waiting: make(map[string]chan *Prediction),
+		early:   make(map[string]earlyWebhook),
+	}
+}
+
+// serves the receiver on the listener until ctx is done
+func (r *WebhookReceiver) Serve(ctx context.Context, listener net.Listener) error {
+	server := &http.Server{Handler: r}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			server.Close()
+		case <-done:
+		}
+	}()
+
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// checks the webhook signature headers against the body
+func (r *WebhookReceiver) Verify(header http.Header, body []byte, now time.Time) error {
+	id, timestamp, signatures := header.Get("webhook-id"), header.Get("webhook-timestamp"), header.Get("webhook-signature")
+	if id == "" || timestamp == "" || signatures == "" {
+		return fmt.Errorf("missing webhook signature headers")
+	}
+
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad webhook timestamp '%s'", timestamp)
+	}
+	if age := now.Sub(time.Unix(secs, 0)); age > WEBHOOK_TOLERANCE || age < -WEBHOOK_TOLERANCE {
+		return fmt.Errorf("webhook timestamp is too far from now")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Secret, WEBHOOK_SECRET_PREFIX))
+	if err != nil {
+		return fmt.Errorf("bad webhook secret: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%s.", id, timestamp)
+	mac.Write(body)
+	expected := mac.Sum(nil)
+
+	// there can be several signatures, eg. while the secret is being rotated
+	for _, signature := range strings.Fields(signatures) {
+		version, sig, ok := strings.Cut(signature, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+
+		if decoded, err := base64.StdEncoding.DecodeString(sig); err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no matching webhook signature")
+}
+
+func (r *WebhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, WEBHOOK_MAX_BODY))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := r.Verify(req.Header, body, time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	var prediction Prediction
+	if err := json.Unmarshal(body, &prediction); err != nil || prediction.ID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.deliver(&prediction, time.Now())
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *WebhookReceiver) deliver(prediction *Prediction, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, early := range r.early {
+		if now.Sub(early.Received) > WEBHOOK_EARLY_TTL {
+			delete(r.early, id)
+		}
+	}
+
+	if ch, ok := r.waiting[prediction.ID]; ok {
+		select {
+		case ch <- prediction:
+		default: // a retried delivery, the first one is enough
+		}
+		return
+	}
+
+	r.early[prediction.ID] = earlyWebhook{Prediction: prediction, Received: now}
+}
+
+// returns a channel the prediction is sent on once its webhook arrives
+func (r *WebhookReceiver) wait(id string) <-chan *Prediction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ch := make(chan *Prediction, 1)
+	if early, ok := r.early[id]; ok {
+		ch <- early.Prediction
+		delete(r.early, id)
+	}
+
+	r.waiting[id] = ch
+	return ch
+}
+
+func (r *WebhookReceiver) forget(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.waiting, id)
+}
+
+// fetches the secret webhooks are signed with, see
+// https://replicate.com/docs/reference/http#webhooks.default.secret.get
+func (c *ReplicateClient) GetWebhookSecret(ctx context.Context) (string, error) {
+	var secret struct {
+		Key string `json:"key"`
+	}
+	if err := c.do(ctx, "GET", "/webhooks/default/secret", nil, http.StatusOK, &secret); err != nil {
+		return "", fmt.Errorf("failed to get webhook secret: %w", err)
+	}
+
+	return secret.Key, nil
+}
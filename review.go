@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	REVIEW_HELP = "[a]ccept, regenerate [s]ection <n>, regenerate [i]mage <n>, regenerate [t]ags, [e]dit title, [q]uit"
+)
+
+func printReview(bw *BlogWriter) {
+	fmt.Printf("\nTitle: %s\n", bw.Title)
+	fmt.Printf("Output: %s\n", bw.outDir)
+
+	fmt.Println("Outline:")
+	for i, section := range splitSections(bw.Content) {
+		heading := section.Heading
+		if heading == "" {
+			heading = "(introduction)"
+		}
+		fmt.Printf("\t%d. %s (%d words)\n", i+1, heading, len(strings.Fields(section.Text)))
+	}
+
+	fmt.Printf("Tags: %s\n", bw.Tags)
+
+	fmt.Println("Images:")
+	for i, img := range bw.images() {
+		thumbnail := ""
+		if i == 0 {
+			thumbnail = " (thumbnail)"
+		}
+		fmt.Printf("\t%d. %s%s: %s\n", i, img.File, thumbnail, img.Alt)
+	}
+	fmt.Println()
+}
+
+// lets the user inspect and fix up the generated post before it's written. returns
+// false if the post should be thrown away
+func reviewPost(bw *BlogWriter) bool {
+	p := newPrompter()
+	for {
+		printReview(bw)
+
+		args := strings.Fields(p.ask(REVIEW_HELP, "a"))
+		if len(args) == 0 {
+			continue
+		}
+
+		// most commands take an index
+		n := -1
+		if len(args) > 1 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil {
+				util.Warning("'%s' isn't a number", args[1])
+				continue
+			}
+		}
+
+		var err error
+		switch args[0] {
+		case "a", "accept":
+			return true
+		case "q", "quit":
+			return false
+		case "s", "section":
+			if sections := splitSections(bw.Content); n < 1 || n > len(sections) {
+				util.Warning("Pick a section between 1 and %d", len(sections))
+				continue
+			}
+			instructions := p.ask("Instructions for the rewrite (optional)", "")
+			err = bw.regenSection(n-1, instructions)
+		case "i", "image":
+			images := bw.images()
+			if n < 0 || n >= len(images) {
+				util.Warning("Pick an image between 0 and %d", len(images)-1)
+				continue
+			}
+			err = bw.regenImage(n, p.ask("Image prompt", images[n].Alt))
+		case "t", "tags":
+			err = bw.regenTags()
+		case "e", "edit":
+			err = bw.renameTitle(p.ask("Title", bw.Title))
+		default:
+			util.Warning("Unknown command '%s'", args[0])
+		}
+
+		if err != nil {
+			util.Warning("%v", err)
+		}
+	}
+}
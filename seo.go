@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"git.openpunk.com/CPunch/copywriter/prompts"
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	META_DESCRIPTION_MIN   = 50
+	META_DESCRIPTION_MAX   = 160
+	FOCUS_KEYWORD_MAX      = 60
+	SLUG_MAX               = 60
+	SOCIAL_TITLE_MAX       = 70
+	SOCIAL_DESCRIPTION_MAX = 200
+	TWITTER_CARD           = "summary_large_image"
+)
+
+/*
+	SEO metadata is written to the front matter for the theme's SEO partials:
+
+	description: "..."
+	focusKeyword: "..."
+	slug: "..."
+	og:
+	  title: "..."
+	  description: "..."
+	  image: "file_1.jpg"
+	  type: "article"
+	twitter:
+	  card: "summary_large_image"
+	  title: "..."
+	  description: "..."
+	  image: "file_1.jpg"
+*/
+
+type SEO struct {
+	Description       string `json:"description"`
+	FocusKeyword      string `json:"focusKeyword"`
+	Slug              string `json:"slug"`
+	SocialTitle       string `json:"socialTitle"`
+	SocialDescription string `json:"socialDescription"`
+}
+
+// returns everything wrong with the metadata, nil if it's fine
+func (s *SEO) problems() []string {
+	var problems []string
+	if n := utf8.RuneCountInString(s.Description); n < META_DESCRIPTION_MIN || n > META_DESCRIPTION_MAX {
+		problems = append(problems, fmt.Sprintf("description is %d characters, it should be %d to %d", n, META_DESCRIPTION_MIN, META_DESCRIPTION_MAX))
+	}
+
+	if s.FocusKeyword == "" || utf8.RuneCountInString(s.FocusKeyword) > FOCUS_KEYWORD_MAX {
+		problems = append(problems, fmt.Sprintf("focus keyword should be 1 to %d characters", FOCUS_KEYWORD_MAX))
+	} else if !strings.Contains(strings.ToLower(s.Description), strings.ToLower(s.FocusKeyword)) {
+		problems = append(problems, "description doesn't mention the focus keyword")
+	}
+
+	if s.Slug == "" || utf8.RuneCountInString(s.Slug) > SLUG_MAX || s.Slug != slugify(s.Slug) {
+		problems = append(problems, fmt.Sprintf("slug '%s' should be 1 to %d lowercase characters", s.Slug, SLUG_MAX))
+	} else if keyword := slugify(s.FocusKeyword); keyword != "" && !strings.Contains(s.Slug, keyword) {
+		problems = append(problems, "slug doesn't contain the focus keyword")
+	}
+
+	if s.SocialTitle == "" || utf8.RuneCountInString(s.SocialTitle) > SOCIAL_TITLE_MAX {
+		problems = append(problems, fmt.Sprintf("social title should be 1 to %d characters", SOCIAL_TITLE_MAX))
+	}
+
+	if s.SocialDescription == "" || utf8.RuneCountInString(s.SocialDescription) > SOCIAL_DESCRIPTION_MAX {
+		problems = append(problems, fmt.Sprintf("social description should be 1 to %d characters", SOCIAL_DESCRIPTION_MAX))
+	}
+
+	return problems
+}
+
+// lowercase words separated by single '-', anything else is dropped
+func slugify(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+
+	return strings.Join(words, "-")
+}
+
+// cuts text down to max characters, on a word boundary if possible
+func truncateText(text string, max int) string {
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	runes := []rune(text)[:max]
+	cut := string(runes)
+	if i := strings.LastIndexAny(cut, " -"); i > 0 {
+		cut = cut[:i]
+	}
+
+	return strings.TrimRight(cut, " -,;:")
+}
+
+// forces the metadata within its limits, filling anything missing from the post. only
+// lengths are fixed, callers should check problems() afterwards
+func (s *SEO) fit(bw *BlogWriter) {
+	if s.FocusKeyword == "" {
+		var tags []string
+		if json.Unmarshal([]byte(bw.Tags), &tags) == nil && len(tags) > 0 {
+			s.FocusKeyword = tags[0]
+		}
+	}
+	s.FocusKeyword = truncateText(s.FocusKeyword, FOCUS_KEYWORD_MAX)
+
+	if s.Description == "" {
+		s.Description = bw.Title
+	}
+	s.Description = truncateText(s.Description, META_DESCRIPTION_MAX)
+
+	s.Slug = slugify(s.Slug)
+	if s.Slug == "" {
+		s.Slug = slugify(bw.Title)
+	}
+	s.Slug = truncateText(s.Slug, SLUG_MAX)
+
+	if s.SocialTitle == "" {
+		s.SocialTitle = bw.Title
+	}
+	s.SocialTitle = truncateText(s.SocialTitle, SOCIAL_TITLE_MAX)
+
+	if s.SocialDescription == "" {
+		s.SocialDescription = s.Description
+	}
+	s.SocialDescription = truncateText(s.SocialDescription, SOCIAL_DESCRIPTION_MAX)
+}
+
+func (bw *BlogWriter) genSEO() (*SEO, error) {
+	util.Info("Generating SEO metadata...")
+	vars := bw.promptVars()
+	vars.Content = bw.Content
+	prompt, err := prompts.Render(prompts.SEO, vars)
+	if err != nil {
+		return nil, err
+	}
+
+	seo := &SEO{}
+	for i := 0; i < MAX_RETRY; i++ {
+		response, err := util.GenerateResponse(util.ResponseOptions{
+			Ctx:       bw.ctx,
+			MaxTokens: 300,
+			Prompt:    prompt,
+			Stub:      `{"focusKeyword": "dry run", "description": "A dry run of the article, the generated meta description would go here.", "slug": "dry-run", "socialTitle": "Dry run", "socialDescription": "A dry run of the article."}`,
+			UseGPT4:   false,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var attempt SEO
+		if err := json.Unmarshal([]byte(strings.ReplaceAll(response, "```", "")), &attempt); err != nil {
+			continue
+		}
+		seo = &attempt
+
+		problems := seo.problems()
+		if len(problems) == 0 {
+			return seo, nil
+		}
+		util.Warning("Bad SEO metadata: %s", strings.Join(problems, ", "))
+	}
+
+	// use the last attempt, cut down to size
+	util.Warning("GPT failed to generate valid SEO metadata, fitting it to the limits")
+	seo.fit(bw)
+	if problems := seo.problems(); len(problems) > 0 {
+		util.Warning("SEO metadata still needs fixing by hand: %s", strings.Join(problems, ", "))
+	}
+	return seo, nil
+}
+
+// front matter lines for the metadata
+func (s *SEO) headers(image string) string {
+	q := strconv.Quote
+	return fmt.Sprintf(
+		"description: %s\nfocusKeyword: %s\nslug: %s\n"+
+			"og:\n  title: %s\n  description: %s\n  image: %s\n  type: \"article\"\n"+
+			"twitter:\n  card: %s\n  title: %s\n  description: %s\n  image: %s\n",
+		q(s.Description), q(s.FocusKeyword), q(s.Slug),
+		q(s.SocialTitle), q(s.SocialDescription), q(image),
+		q(TWITTER_CARD), q(s.SocialTitle), q(s.SocialDescription), q(image),
+	)
+}
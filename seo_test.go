@@ -0,0 +1,116 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	slugs := map[string]string{
+		"Hello World":              "hello-world",
+		"  10 Tips -- for   2024!": "10-tips-for-2024",
+		"Crème brûlée":             "crème-brûlée",
+		"already-a-slug":           "already-a-slug",
+		"!!!":                      "",
+	}
+
+	for text, expected := range slugs {
+		if slug := slugify(text); slug != expected {
+			t.Fatalf("slugify(%q) = %q, expected %q", text, slug, expected)
+		}
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	type truncation struct {
+		text string
+		max  int
+	}
+
+	truncations := map[truncation]string{
+		{"short enough", 20}:           "short enough",
+		{"  padded  ", 6}:              "padded",
+		{"cut on a word boundary", 12}: "cut on a",
+		{"how-to-make-bread", 10}:      "how-to",
+		{"ends with, a comma", 10}:     "ends",
+		{"unbrokenword", 5}:            "unbro",
+		{"ééééé", 3}:                   "ééé",
+	}
+
+	for in, expected := range truncations {
+		if text := truncateText(in.text, in.max); text != expected {
+			t.Fatalf("truncateText(%q, %d) = %q, expected %q", in.text, in.max, text, expected)
+		}
+	}
+}
+
+func TestSEOProblems(t *testing.T) {
+	good := SEO{
+		Description:       "Learn how to bake sourdough bread at home with a simple starter and a hot oven.",
+		FocusKeyword:      "sourdough bread",
+		Slug:              "how-to-bake-sourdough-bread",
+		SocialTitle:       "Baking sourdough bread",
+		SocialDescription: "A simple sourdough recipe.",
+	}
+
+	// each change breaks the metadata in a way problems() should mention
+	broken := map[string]func(s *SEO){
+		"description is 15 characters":          func(s *SEO) { s.Description = "sourdough bread" },
+		"description is 161 characters":         func(s *SEO) { s.Description = "sourdough bread " + strings.Repeat("a", 145) },
+		"focus keyword should be":               func(s *SEO) { s.FocusKeyword = "" },
+		"doesn't mention the focus keyword":     func(s *SEO) { s.Description = strings.Replace(s.Description, "sourdough bread", "a loaf", 1) },
+		"slug 'How-To' should be":               func(s *SEO) { s.Slug = "How-To" },
+		"slug 'crème-brûlée-sourdough-bread":    func(s *SEO) { s.Slug = "crème-brûlée" + strings.Repeat("-sourdough-bread", 4) },
+		"slug doesn't contain the focus":        func(s *SEO) { s.Slug = "how-to-bake" },
+		"social title should be":                func(s *SEO) { s.SocialTitle = strings.Repeat("a", SOCIAL_TITLE_MAX+1) },
+		"social description should be 1 to 200": func(s *SEO) { s.SocialDescription = "" },
+	}
+
+	if problems := good.problems(); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	// limits are in characters, not bytes
+	accented := good
+	accented.Slug = "sourdough-bread-" + strings.Repeat("é", SLUG_MAX-len("sourdough-bread-"))
+	if problems := accented.problems(); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	accented.Slug += "é"
+	if problems := accented.problems(); len(problems) != 1 {
+		t.Fatalf("expected the slug to be too long, got %v", problems)
+	}
+
+	for expected, breakIt := range broken {
+		seo := good
+		breakIt(&seo)
+
+		problems := seo.problems()
+		if len(problems) != 1 || !strings.Contains(problems[0], expected) {
+			t.Fatalf("expected a problem containing %q, got %v", expected, problems)
+		}
+	}
+}
+
+func TestSEOFit(t *testing.T) {
+	bw := &BlogWriter{Title: "How to Bake Sourdough Bread", Tags: `["sourdough bread", "baking"]`}
+
+	seo := &SEO{Description: "Bread " + strings.Repeat("and more bread ", 20)}
+	seo.fit(bw)
+
+	// lengths are fixed, but the keyword can't be conjured into the description
+	problems := seo.problems()
+	if len(problems) != 1 || !strings.Contains(problems[0], "doesn't mention the focus keyword") {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	if seo.Slug != "how-to-bake-sourdough-bread" || seo.FocusKeyword != "sourdough bread" {
+		t.Fatalf("unexpected fit: %+v", seo)
+	}
+
+	// accented slugs are cut to the same limit they're checked against
+	seo = &SEO{Description: "Crème brûlée and sourdough bread, " + strings.Repeat("a dessert ", 5), FocusKeyword: "crème brûlée", Slug: strings.Repeat("crème-brûlée-", 10)}
+	seo.fit(bw)
+	if problems := seo.problems(); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/thumbnail"
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+const (
+	SOCIAL_CARD_WIDTH  = 1200
+	SOCIAL_CARD_HEIGHT = 630
+	SOCIAL_CARD_SUFFIX = "-social.jpg"
+	MAX_UPSCALE        = 4 // most upscalers only go up to 4x
+)
+
+/*
+	What the thumbnail stage did, kept in the front matter so themes can use it:
+
+	thumbnail:
+	  width: 1200
+	  height: 675
+	  crop: "entropy"
+	  upscaled: true
+	  social: "file_1-social.jpg"
+*/
+
+// what processThumbnail did to the thumbnail
+type ThumbnailInfo struct {
+	Width    int    `yaml:"width"`
+	Height   int    `yaml:"height"`
+	Crop     string `yaml:"crop,omitempty"` // empty if it wasn't cropped
+	Upscaled bool   `yaml:"upscaled"`
+	Social   string `yaml:"social,omitempty"` // social card file, if one was made
+}
+
+// front matter lines for the thumbnail
+func (t *ThumbnailInfo) headers() string {
+	lines := fmt.Sprintf("thumbnail:\n  width: %d\n  height: %d\n", t.Width, t.Height)
+	if t.Crop != "" {
+		lines += fmt.Sprintf("  crop: %s\n", strconv.Quote(t.Crop))
+	}
+	lines += fmt.Sprintf("  upscaled: %t\n", t.Upscaled)
+	if t.Social != "" {
+		lines += fmt.Sprintf("  social: %s\n", strconv.Quote(t.Social))
+	}
+
+	return lines
+}
+
+// splits "owner/name:version" into the model and its (optional) version
+func splitModel(upscaler string) (model, version string) {
+	model, version, _ = strings.Cut(upscaler, ":")
+	return
+}
+
+// true if any part of the thumbnail stage is switched on
+func (config *ConfigData) processesThumbnails() bool {
+	return config.ThumbnailAspect != "" || config.ThumbnailWidth > 0 || config.Upscaler != "" || config.SocialCard
+}
+
+// an image file as a data uri, for sending small images to replicate inline rather
+// than uploading them first
+func imageDataURI(data []byte) string {
+	return "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// runs the upscaler over the image file, replacing it
+func (bw *BlogWriter) upscale(filePath string, scale int) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	prediction, rc, err := runModel(bw.ctx, bw.config, bw.config.Upscaler, map[string]interface{}{
+		"image": imageDataURI(data),
+		"scale": scale,
+	})
+	if err != nil {
+		return err
+	}
+
+	// upscalers return either a single url or a list of them
+	var url string
+	if err := prediction.DecodeOutput(&url); err != nil {
+		var urls []string
+		if err := prediction.DecodeOutput(&urls); err != nil || len(urls) == 0 {
+			return fmt.Errorf("Upscaler returned no image")
+		}
+		url = urls[0]
+	}
+
+	return util.DownloadToFile(util.DownloadOptions{
+		URL:      url,
+		FilePath: filePath,
+		Header:   rc.Header,
+	})
+}
+
+// upscales, crops and scales the thumbnail to fit the theme's cover image, and writes
+// the social card. does nothing unless one of the thumbnail options is set
+func (bw *BlogWriter) processThumbnail() error {
+	if !bw.config.processesThumbnails() || bw.Thumbnail == "" || util.IsDryRun() {
+		return nil
+	}
+
+	util.Info("Processing thumbnail '%s'...", bw.Thumbnail)
+	filePath := path.Join(bw.outDir, bw.Thumbnail)
+	img, err := thumbnail.Load(filePath)
+	if err != nil {
+		return fmt.Errorf("Failed to load thumbnail: %v", err)
+	}
+
+	info := &ThumbnailInfo{}
+	width := bw.config.ThumbnailWidth
+	if bw.config.Upscaler != "" && img.Bounds().Dx() < width {
+		scale := (width + img.Bounds().Dx() - 1) / img.Bounds().Dx()
+		if scale < 2 {
+			scale = 2
+		} else if scale > MAX_UPSCALE {
+			scale = MAX_UPSCALE
+		}
+
+		util.Info("Upscaling thumbnail %dx...", scale)
+		if err := bw.upscale(filePath, scale); err != nil {
+			return fmt.Errorf("Failed to upscale thumbnail: %v", err)
+		}
+
+		if img, err = thumbnail.Load(filePath); err != nil {
+			return fmt.Errorf("Failed to load upscaled thumbnail: %v", err)
+		}
+		info.Upscaled = true
+	}
+	full := img
+
+	if bw.config.ThumbnailAspect != "" {
+		aw, ah, err := thumbnail.ParseAspect(bw.config.ThumbnailAspect)
+		if err != nil {
+			return err
+		}
+		img = thumbnail.CropToAspect(img, aw, ah, bw.config.ThumbnailCrop)
+		info.Crop = bw.config.ThumbnailCrop
+	}
+
+	img = thumbnail.Flatten(thumbnail.FitWidth(img, width))
+	if err := thumbnail.SaveJPEG(filePath, img); err != nil {
+		return fmt.Errorf("Failed to save thumbnail: %v", err)
+	}
+	info.Width, info.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	bw.rehashImage(bw.Thumbnail)
+
+	// cut from the uncropped image, the card has its own aspect ratio
+	if bw.config.SocialCard {
+		info.Social = strings.TrimSuffix(bw.Thumbnail, path.Ext(bw.Thumbnail)) + SOCIAL_CARD_SUFFIX
+		if err := bw.saveSocialCard(full, info.Social); err != nil {
+			return fmt.Errorf("Failed to save social card: %v", err)
+		}
+	}
+
+	bw.ThumbnailInfo = info
+	return nil
+}
+
+func (bw *BlogWriter) saveSocialCard(img image.Image, fileName string) error {
+	card := thumbnail.Cover(img, SOCIAL_CARD_WIDTH, SOCIAL_CARD_HEIGHT, bw.config.ThumbnailCrop)
+	return thumbnail.SaveJPEG(path.Join(bw.outDir, fileName), thumbnail.Flatten(card))
+}
+
+// the image used for og/twitter cards
+func (bw *BlogWriter) socialImage() string {
+	if bw.ThumbnailInfo != nil && bw.ThumbnailInfo.Social != "" {
+		return bw.ThumbnailInfo.Social
+	}
+	return bw.Thumbnail
+}
@@ -0,0 +1,194 @@
+package thumbnail
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	_ "image/gif"
+	_ "image/png"
+
+	"golang.org/x/image/draw"
+)
+
+const (
+	CROP_CENTER  = "center"
+	CROP_ENTROPY = "entropy" // keeps the busiest part of the image, usually the subject
+
+	JPEG_QUALITY = 90
+
+	// the crop window is scored at this many positions, on a copy this wide
+	ENTROPY_STEPS        = 24
+	ENTROPY_SAMPLE_WIDTH = 256
+)
+
+// parses an aspect ratio like "16:9"
+func ParseAspect(aspect string) (w, h int, err error) {
+	ws, hs, ok := strings.Cut(aspect, ":")
+	if ok {
+		w, err = strconv.Atoi(strings.TrimSpace(ws))
+		if err == nil {
+			h, err = strconv.Atoi(strings.TrimSpace(hs))
+		}
+	}
+
+	if !ok || err != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("Invalid aspect ratio '%s', expected eg. '16:9'", aspect)
+	}
+	return w, h, nil
+}
+
+// loads a jpeg, png or gif. the file extension doesn't matter
+func Load(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+func EncodeJPEG(w io.Writer, img image.Image) error {
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEG_QUALITY})
+}
+
+func SaveJPEG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return EncodeJPEG(f, img)
+}
+
+// the largest rect of the given aspect ratio that fits in bounds, at the top left
+func aspectRect(bounds image.Rectangle, aw, ah int) image.Rectangle {
+	w, h := bounds.Dx(), bounds.Dy()
+	if w*ah > h*aw { // too wide
+		w = h * aw / ah
+	} else {
+		h = w * ah / aw
+	}
+
+	return image.Rect(0, 0, w, h).Add(bounds.Min)
+}
+
+// shannon entropy of the rect's grayscale histogram
+func entropy(img *image.Gray, r image.Rectangle) float64 {
+	var histogram [256]int
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			histogram[img.GrayAt(x, y).Y]++
+		}
+	}
+
+	total := float64(r.Dx() * r.Dy())
+	var e float64
+	for _, n := range histogram {
+		if n > 0 {
+			p := float64(n) / total
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// slides the crop window along the axis being cropped, returning the offset of the
+// window with the most entropy
+func entropyOffset(img image.Image, crop image.Rectangle) image.Point {
+	bounds := img.Bounds()
+	slackX, slackY := bounds.Dx()-crop.Dx(), bounds.Dy()-crop.Dy()
+	if slackX == 0 && slackY == 0 {
+		return image.Point{}
+	}
+
+	// score a small grayscale copy, the details don't matter
+	scale := math.Min(1, float64(ENTROPY_SAMPLE_WIDTH)/float64(bounds.Dx()))
+	sw, sh := int(math.Max(1, float64(bounds.Dx())*scale)), int(math.Max(1, float64(bounds.Dy())*scale))
+	sample := image.NewGray(image.Rect(0, 0, sw, sh))
+	draw.ApproxBiLinear.Scale(sample, sample.Bounds(), img, bounds, draw.Src, nil)
+
+	window := image.Rect(0, 0, int(float64(crop.Dx())*scale), int(float64(crop.Dy())*scale))
+	best, bestScore := 0, -1.0
+	for step := 0; step <= ENTROPY_STEPS; step++ {
+		offset := step * int(math.Max(float64(sw-window.Dx()), float64(sh-window.Dy()))) / ENTROPY_STEPS
+		r := window.Add(image.Pt(offset, 0))
+		if slackY > 0 {
+			r = window.Add(image.Pt(0, offset))
+		}
+
+		if score := entropy(sample, r.Intersect(sample.Bounds())); score > bestScore {
+			best, bestScore = offset, score
+		}
+	}
+
+	// back to full size
+	offset := int(float64(best) / scale)
+	if slackY > 0 {
+		return image.Pt(0, min(offset, slackY))
+	}
+	return image.Pt(min(offset, slackX), 0)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// crops img to the aspect ratio aw:ah, either around the center or the busiest part
+func CropToAspect(img image.Image, aw, ah int, mode string) image.Image {
+	bounds := img.Bounds()
+	crop := aspectRect(bounds, aw, ah)
+
+	var offset image.Point
+	if mode == CROP_ENTROPY {
+		offset = entropyOffset(img, crop)
+	} else {
+		offset = image.Pt((bounds.Dx()-crop.Dx())/2, (bounds.Dy()-crop.Dy())/2)
+	}
+	crop = crop.Add(offset)
+
+	out := image.NewRGBA(image.Rect(0, 0, crop.Dx(), crop.Dy()))
+	draw.Draw(out, out.Bounds(), img, crop.Min, draw.Src)
+	return out
+}
+
+func Resize(img image.Image, width, height int) image.Image {
+	out := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(out, out.Bounds(), img, img.Bounds(), draw.Src, nil)
+	return out
+}
+
+// scales img down to width, keeping its aspect ratio. smaller images are left alone
+func FitWidth(img image.Image, width int) image.Image {
+	bounds := img.Bounds()
+	if width <= 0 || bounds.Dx() <= width {
+		return img
+	}
+
+	return Resize(img, width, bounds.Dy()*width/bounds.Dx())
+}
+
+// crops and scales img to exactly width x height, eg. for social cards
+func Cover(img image.Image, width, height int, mode string) image.Image {
+	return Resize(CropToAspect(img, width, height, mode), width, height)
+}
+
+// makes sure img has no transparency left, jpegs can't store it
+func Flatten(img image.Image) image.Image {
+	out := image.NewRGBA(img.Bounds())
+	draw.Draw(out, out.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Over)
+	return out
+}
@@ -0,0 +1,91 @@
+package thumbnail
+
+import (
+	"image"
+	"image/color"
+	"path"
+	"testing"
+)
+
+// a flat gray image with a noisy square at x, which entropy cropping should find
+func testImage(w, h, x int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for py := 0; py < h; py++ {
+		for px := 0; px < w; px++ {
+			c := color.RGBA{128, 128, 128, 255}
+			if px >= x && px < x+h {
+				v := uint8((px*31 + py*17) ^ (px * py))
+				c = color.RGBA{v, v / 2, 255 - v, 255}
+			}
+			img.Set(px, py, c)
+		}
+	}
+	return img
+}
+
+func TestParseAspect(t *testing.T) {
+	if w, h, err := ParseAspect("16:9"); err != nil || w != 16 || h != 9 {
+		t.Fatalf("got %d:%d, %v", w, h, err)
+	}
+
+	for _, bad := range []string{"", "16", "16:0", "a:b", "-1:2"} {
+		if _, _, err := ParseAspect(bad); err == nil {
+			t.Errorf("'%s' should be invalid", bad)
+		}
+	}
+}
+
+func TestCropToAspect(t *testing.T) {
+	img := testImage(900, 300, 600)
+
+	center := CropToAspect(img, 1, 1, CROP_CENTER)
+	if b := center.Bounds(); b.Dx() != 300 || b.Dy() != 300 {
+		t.Fatalf("unexpected size %v", b)
+	}
+	// the center of the image is flat
+	if c := color.RGBAModel.Convert(center.At(150, 150)).(color.RGBA); c.R != 128 || c.G != 128 {
+		t.Fatalf("center crop isn't centered, got %v", c)
+	}
+
+	// the busy square is on the right
+	busy := CropToAspect(img, 1, 1, CROP_ENTROPY)
+	if c := color.RGBAModel.Convert(busy.At(150, 150)).(color.RGBA); c.R == 128 && c.G == 128 {
+		t.Fatal("entropy crop missed the busy part of the image")
+	}
+
+	// tall images are cropped vertically
+	tall := CropToAspect(testImage(300, 900, 0), 16, 9, CROP_ENTROPY)
+	if b := tall.Bounds(); b.Dx() != 300 || b.Dy() != 168 {
+		t.Fatalf("unexpected size %v", b)
+	}
+}
+
+func TestResize(t *testing.T) {
+	img := testImage(800, 400, 0)
+
+	if b := FitWidth(img, 200).Bounds(); b.Dx() != 200 || b.Dy() != 100 {
+		t.Fatalf("unexpected size %v", b)
+	}
+	if FitWidth(img, 1600) != img || FitWidth(img, 0) != img {
+		t.Fatal("FitWidth shouldn't scale up")
+	}
+
+	if b := Cover(img, 1200, 630, CROP_CENTER).Bounds(); b.Dx() != 1200 || b.Dy() != 630 {
+		t.Fatalf("unexpected size %v", b)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	file := path.Join(t.TempDir(), "thumb.jpg")
+	if err := SaveJPEG(file, Flatten(testImage(64, 32, 0))); err != nil {
+		t.Fatal(err)
+	}
+
+	img, err := Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 64 || b.Dy() != 32 {
+		t.Fatalf("unexpected size %v", b)
+	}
+}
@@ -0,0 +1,89 @@
+package topicqueue
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/util"
+)
+
+/*
+	A queue file is a plain text file maintained by editors, one keyword list or
+	short brief per line:
+
+		# comments start with a '#'
+		low carb breakfast ideas, keto, quick meals
+		Why intermittent fasting isn't for everyone
+
+	Once an entry has been written about it's rewritten in place as a comment
+	recording when it was consumed and which post it produced:
+
+		# done 2023-08-20 15:04:05 -> low-carb-breakfast-ideas: low carb breakfast ideas, keto, quick meals
+
+	so scheduled runs walk through the list exactly once.
+*/
+
+const (
+	COMMENT_PREFIX  = "#"
+	CONSUMED_PREFIX = "# done "
+)
+
+type Entry struct {
+	Text string
+}
+
+func readLines(filename string) ([]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Split(string(data), "\n"), nil
+}
+
+func isEntry(line string) bool {
+	line = strings.TrimSpace(line)
+	return line != "" && !strings.HasPrefix(line, COMMENT_PREFIX)
+}
+
+// returns the next unused entry in the queue file
+func NextEntry(filename string) (*Entry, error) {
+	lines, err := readLines(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		if isEntry(line) {
+			return &Entry{Text: strings.TrimSpace(line)}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No unused entries left in queue file '%s'", filename)
+}
+
+// marks the entry as consumed, recording the time and the slug of the resulting post.
+// the entry is matched by its text so edits made to the file while a post was being
+// written are preserved
+func MarkConsumed(filename string, entry *Entry, slug string) error {
+	lines, err := readLines(filename)
+	if err != nil {
+		return err
+	}
+
+	for i, line := range lines {
+		if isEntry(line) && strings.TrimSpace(line) == entry.Text {
+			lines[i] = fmt.Sprintf("%s%s -> %s: %s", CONSUMED_PREFIX, util.GetTimeString(), slug, entry.Text)
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+
+			return os.WriteFile(filename, []byte(strings.Join(lines, "\n")), info.Mode())
+		}
+	}
+
+	return fmt.Errorf("Entry '%s' not found in queue file '%s'", entry.Text, filename)
+}
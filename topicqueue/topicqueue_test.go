@@ -0,0 +1,50 @@
+package topicqueue
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	filename := path.Join(t.TempDir(), "queue.txt")
+	if err := os.WriteFile(filename, []byte("# keywords\n\nketo breakfast\nmeal prep tips\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := NextEntry(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Text != "keto breakfast" {
+		t.Fatalf("expected 'keto breakfast', got '%s'", entry.Text)
+	}
+
+	if err := MarkConsumed(filename, entry, "keto-breakfast"); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err = NextEntry(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry.Text != "meal prep tips" {
+		t.Fatalf("expected 'meal prep tips', got '%s'", entry.Text)
+	}
+
+	data, _ := os.ReadFile(filename)
+	if !strings.Contains(string(data), "-> keto-breakfast: keto breakfast") {
+		t.Fatalf("consumed entry not marked:\n%s", data)
+	}
+
+	if err := MarkConsumed(filename, entry, "meal-prep-tips"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NextEntry(filename); err == nil {
+		t.Fatal("expected exhausted queue to return an error")
+	}
+}
@@ -6,13 +6,26 @@ import (
 	"math/rand"
 	"strings"
 
+	"git.openpunk.com/CPunch/copywriter/prompts"
 	"git.openpunk.com/CPunch/copywriter/util"
 	"github.com/groovili/gogtrends"
 )
 
-func ScrapePopularTrends(category string) (title, article string, _ error) {
+// splits a locale (eg. "en-US") into the language and location google trends expects
+func splitLocale(locale string) (hl, loc string) {
+	hl = locale
+	if i := strings.LastIndex(locale, "-"); i != -1 {
+		loc = strings.ToUpper(locale[i+1:])
+	} else {
+		loc = strings.ToUpper(locale)
+	}
+	return
+}
+
+func ScrapePopularTrends(ctx context.Context, category, locale string) (title, article string, _ error) {
 	util.Info("Scraping google trends in category '%s'...", category)
-	stories, err := gogtrends.Realtime(context.Background(), "en-US", "US", category)
+	hl, loc := splitLocale(locale)
+	stories, err := gogtrends.Realtime(ctx, hl, loc, category)
 	if err != nil {
 		return "", "", err
 	}
@@ -26,9 +39,15 @@ func ScrapePopularTrends(category string) (title, article string, _ error) {
 		trends = trends[:10]
 	}
 
+	prompt, err := prompts.Render(prompts.TREND_KEYWORDS, prompts.Vars{Trends: trends, Locale: locale})
+	if err != nil {
+		return "", "", err
+	}
+
 	resp, err := util.GenerateResponse(util.ResponseOptions{
+		Ctx:       ctx,
 		MaxTokens: 100,
-		Prompt:    fmt.Sprintf("%s\n---\nWrite some keywords for the above articles: ", strings.Join(trends, "\n")),
+		Prompt:    prompt,
 		UseGPT4:   false,
 		Clean:     false,
 	})
@@ -39,15 +58,57 @@ func ScrapePopularTrends(category string) (title, article string, _ error) {
 	return fmt.Sprintf("The following is a list of topics that readers might be interested in:\n%s", resp), "", nil
 }
 
-func ScrapeRealtimeNews(category string) (title, article string, _ error) {
+func ScrapeRealtimeNews(ctx context.Context, category, locale string) (title, article string, _ error) {
 	util.Info("Scraping stories in category '%s'...", category)
-	stories, err := gogtrends.Realtime(context.Background(), "en-US", "US", category)
+	hl, loc := splitLocale(locale)
+	stories, err := gogtrends.Realtime(ctx, hl, loc, category)
 	if err != nil {
 		// Fail("Failed to scrape google trends: %s", err.Error())
 		return "", "", err
 	}
 
-	story := stories[rand.Intn(len(stories))]
+	return summarizeStory(ctx, stories[rand.Intn(len(stories))])
+}
+
+// like ScrapeRealtimeNews, but picks the story most related to the keywords. used to
+// pull fresh context for existing articles
+func ScrapeRelatedNews(ctx context.Context, category, locale string, keywords []string) (title, article string, _ error) {
+	util.Info("Scraping stories related to '%s' in category '%s'...", strings.Join(keywords, ", "), category)
+	hl, loc := splitLocale(locale)
+	stories, err := gogtrends.Realtime(ctx, hl, loc, category)
+	if err != nil {
+		return "", "", err
+	}
+
+	var best *gogtrends.TrendingStory
+	bestScore := 0
+	for _, story := range stories {
+		text := story.Title
+		for _, article := range story.Articles {
+			text += " " + article.Title + " " + article.Snippet
+		}
+		text = strings.ToLower(text)
+
+		score := 0
+		for _, keyword := range keywords {
+			if keyword != "" && strings.Contains(text, strings.ToLower(keyword)) {
+				score++
+			}
+		}
+
+		if score > bestScore {
+			best, bestScore = story, score
+		}
+	}
+
+	if best == nil {
+		return "", "", fmt.Errorf("No stories related to '%s' found", strings.Join(keywords, ", "))
+	}
+
+	return summarizeStory(ctx, best)
+}
+
+func summarizeStory(ctx context.Context, story *gogtrends.TrendingStory) (title, article string, _ error) {
 	articles := story.Articles
 	if len(articles) > 3 {
 		articles = articles[:3]
@@ -55,20 +116,20 @@ func ScrapeRealtimeNews(category string) (title, article string, _ error) {
 
 	title = "The following is a list of articles related to the topic:\n"
 
-	var context string
+	var sources string
 	for _, article := range articles {
 		content, err := util.ScrapeArticle(article.URL)
 		if err != nil { // just skip the article
 			util.Warning("Failed to scrape %s: %s", article.URL, err.Error())
 			continue
 		}
-		context += fmt.Sprintf("# %s\n%s\n\n", article.Title, content)
+		sources += fmt.Sprintf("# %s\n%s\n\n", article.Title, content)
 		title += fmt.Sprintf("%s\n", article.Title)
 		// ctx.Keywords = append(ctx.Keywords, article.Title)
 	}
 
 	util.Info("Summarizing context...")
-	resp, err := util.SummarizeText(context)
+	resp, err := util.SummarizeText(ctx, sources)
 	if err != nil {
 		return "", "", err
 	}
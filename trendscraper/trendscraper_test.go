@@ -1,6 +1,7 @@
 package trendscraper
 
 import (
+	"context"
 	"fmt"
 	"testing"
 )
@@ -10,7 +11,7 @@ import (
 // }
 
 func TestSEOContext(t *testing.T) {
-	title, article, err := ScrapePopularTrends("m")
+	title, article, err := ScrapePopularTrends(context.Background(), "m", "en-US")
 	if err != nil {
 		t.Error(err)
 	}
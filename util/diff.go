@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// returns a line-by-line diff of a and b. unchanged lines are prefixed with ' ',
+// removed lines with '-' and added lines with '+'. unchanged runs longer than
+// 2*context lines are collapsed
+func LineDiff(a, b string, context int) string {
+	aLines := strings.Split(a, "\n")
+	bLines := strings.Split(b, "\n")
+
+	// longest common subsequence table, lcs[i][j] is the lcs of aLines[i:] and bLines[j:]
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []string
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			lines = append(lines, " "+aLines[i])
+			i++
+			j++
+		case i < len(aLines) && (j == len(bLines) || lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "-"+aLines[i])
+			i++
+		default:
+			lines = append(lines, "+"+bLines[j])
+			j++
+		}
+	}
+
+	return collapseUnchanged(lines, context)
+}
+
+func collapseUnchanged(lines []string, context int) string {
+	var out []string
+	for start := 0; start < len(lines); {
+		if lines[start][0] != ' ' {
+			out = append(out, lines[start])
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(lines) && lines[end][0] == ' ' {
+			end++
+		}
+
+		if end-start > 2*context {
+			out = append(out, lines[start:start+context]...)
+			out = append(out, fmt.Sprintf("@@ %d unchanged lines @@", end-start-2*context))
+			out = append(out, lines[end-context:end]...)
+		} else {
+			out = append(out, lines[start:end]...)
+		}
+		start = end
+	}
+
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,17 @@
+package util
+
+import "testing"
+
+func TestLineDiff(t *testing.T) {
+	a := "one\ntwo\nthree\nfour\nfive\nsix\nseven"
+	b := "one\n2\nthree\nfour\nfive\nsix\nseven\neight"
+
+	expected := " one\n-two\n+2\n three\n@@ 3 unchanged lines @@\n seven\n+eight"
+	if diff := LineDiff(a, b, 1); diff != expected {
+		t.Fatalf("unexpected diff:\n%s", diff)
+	}
+
+	if diff := LineDiff(a, a, 0); diff != "@@ 7 unchanged lines @@" {
+		t.Fatalf("unexpected diff:\n%s", diff)
+	}
+}
@@ -0,0 +1,60 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+/*
+	In dry-run mode nothing that costs money is done. Prompts that would've been sent
+	to the LLM or an image provider are recorded (and printed) instead, and callers get
+	a stub response back so the rest of the pipeline can still run.
+*/
+
+const (
+	DEFAULT_DRY_RUN_RESPONSE = "dry run response"
+)
+
+type DryRunPrompt struct {
+	Kind   string // eg. "llm" or "image (replicate)"
+	Prompt string
+}
+
+var (
+	dryRun        bool
+	dryRunPrompts []DryRunPrompt
+	dryRunMu      sync.Mutex // images are generated concurrently
+)
+
+func EnableDryRun() {
+	dryRun = true
+}
+
+func IsDryRun() bool {
+	return dryRun
+}
+
+// records and prints a prompt that would've been sent
+func RecordPrompt(kind, prompt string) {
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+
+	dryRunPrompts = append(dryRunPrompts, DryRunPrompt{Kind: kind, Prompt: prompt})
+	fmt.Printf("[%s] %s prompt #%d:\n%s\n\n", color.MagentaString("DRY RUN"), kind, len(dryRunPrompts), prompt)
+}
+
+func RecordedPrompts() []DryRunPrompt {
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+
+	return dryRunPrompts
+}
+
+func ClearRecordedPrompts() {
+	dryRunMu.Lock()
+	defer dryRunMu.Unlock()
+
+	dryRunPrompts = nil
+}
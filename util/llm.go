@@ -7,6 +7,7 @@ import (
 	"time"
 	"unicode"
 
+	"git.openpunk.com/CPunch/copywriter/prompts"
 	openai "github.com/sashabaranov/go-openai"
 )
 
@@ -30,6 +31,8 @@ type ResponseOptions struct {
 	*/
 	Clean                 bool
 	CleanKeepPunctuations bool
+	Stub                  string          // returned in dry-run mode, defaults to DEFAULT_DRY_RUN_RESPONSE
+	Ctx                   context.Context // canceling it stops the request (and retries), defaults to context.Background()
 }
 
 func init() {
@@ -53,12 +56,24 @@ func GenerateResponse(args ResponseOptions) (string, error) {
 	}
 
 	// Info("Generating response with prompt:\n%s", args.Prompt)
+	if IsDryRun() {
+		RecordPrompt("llm ("+model+")", args.Prompt)
+		if args.Stub != "" {
+			return args.Stub, nil
+		}
+		return DEFAULT_DRY_RUN_RESPONSE, nil
+	}
+
+	ctx := args.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 
 	var err error
 	var resp openai.ChatCompletionResponse
 	for i := 0; i < MAX_CHAT_RETRY; i++ {
 		resp, err = client.CreateChatCompletion(
-			context.Background(),
+			ctx,
 			openai.ChatCompletionRequest{
 				Model:       model,
 				MaxTokens:   args.MaxTokens,
@@ -74,6 +89,9 @@ func GenerateResponse(args ResponseOptions) (string, error) {
 
 		if err != nil {
 			// not recoverable errors
+			if ctx.Err() != nil {
+				return "", ctx.Err()
+			}
 			if err == openai.ErrChatCompletionInvalidModel ||
 				err == openai.ErrChatCompletionStreamNotSupported ||
 				err == openai.ErrCompletionRequestPromptTypeNotSupported ||
@@ -88,7 +106,11 @@ func GenerateResponse(args ResponseOptions) (string, error) {
 			}
 
 			// try again but sleep for a bit
-			time.Sleep(timeToSleep)
+			select {
+			case <-time.After(timeToSleep):
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
 			continue
 		}
 
@@ -119,7 +141,8 @@ func GenerateResponse(args ResponseOptions) (string, error) {
 	return "", fmt.Errorf("ChatCompletion error: %v", err)
 }
 
-func SummarizeText(text string) (string, error) {
+// summarizes the text in chunks, canceling ctx stops the requests
+func SummarizeText(ctx context.Context, text string) (string, error) {
 	size := 8096
 	chunks := []string{}
 	for i := 0; i < len(text); i += size {
@@ -131,13 +154,18 @@ func SummarizeText(text string) (string, error) {
 	}
 
 	var summary string
-	var err error
 	for _, chunk := range chunks {
+		prompt, err := prompts.Render(prompts.SUMMARY, prompts.Vars{Summary: summary, Content: chunk})
+		if err != nil {
+			return "", err
+		}
+
 		summary, err = GenerateResponse(ResponseOptions{
+			Ctx:       ctx,
 			MaxTokens: 6000,
 			UseGPT4:   false,
 			UseLong:   true,
-			Prompt:    fmt.Sprintf("Summarize the following text while retaining all relevant information:\n\n%s\n%s\n\nSummary:", summary, chunk),
+			Prompt:    prompt,
 		})
 		if err != nil {
 			return "", err
@@ -147,3 +175,44 @@ func SummarizeText(text string) (string, error) {
 	Info("Summary: %s", summary)
 	return summary, nil
 }
+
+// scrapes each url and summarizes them together, urls that fail to scrape are skipped
+func SummarizeArticles(ctx context.Context, urls []string) (string, error) {
+	var text string
+	for _, url := range urls {
+		content, err := ScrapeArticle(url)
+		if err != nil { // just skip the article
+			Warning("Failed to scrape %s: %s", url, err.Error())
+			continue
+		}
+		text += fmt.Sprintf("# %s\n%s\n\n", url, content)
+	}
+
+	return SummarizeText(ctx, text)
+}
+
+// embeds the text for similarity search. returns nil in dry-run mode
+func Embed(text string) ([]float32, error) {
+	return EmbedContext(context.Background(), text)
+}
+
+// same as Embed, canceling ctx stops the request
+func EmbedContext(ctx context.Context, text string) ([]float32, error) {
+	if IsDryRun() {
+		RecordPrompt("embedding ("+openai.AdaEmbeddingV2.String()+")", text)
+		return nil, nil
+	}
+
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: []string{text},
+		Model: openai.AdaEmbeddingV2,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Embedding error: %v", err)
+	}
+
+	if len(resp.Data) == 0 {
+		return nil, fmt.Errorf("Embedding error: no embedding returned")
+	}
+	return resp.Data[0].Embedding, nil
+}
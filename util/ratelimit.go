@@ -0,0 +1,41 @@
+package util
+
+import (
+	"sync"
+	"time"
+)
+
+// spaces out calls to Wait so at most perMinute of them return each minute. safe for
+// concurrent use
+type RateLimiter struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time
+}
+
+// a perMinute of 0 or less doesn't limit anything
+func NewRateLimiter(perMinute int) *RateLimiter {
+	l := &RateLimiter{}
+	if perMinute > 0 {
+		l.interval = time.Minute / time.Duration(perMinute)
+	}
+	return l
+}
+
+// blocks until the next call is allowed
+func (l *RateLimiter) Wait() {
+	if l.interval == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter(600) // one every 100ms
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l.Wait()
+		}()
+	}
+	wg.Wait()
+
+	// the first call is free, the other three wait their turn
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("unexpected wait of %v", elapsed)
+	}
+
+	// unlimited
+	start = time.Now()
+	NewRateLimiter(0).Wait()
+	if time.Since(start) > 10*time.Millisecond {
+		t.Fatal("unlimited rate limiter waited")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"git.openpunk.com/CPunch/copywriter/prompts"
+	"git.openpunk.com/CPunch/copywriter/thumbnail"
+	"git.openpunk.com/CPunch/copywriter/util"
+	"github.com/go-ini/ini"
+	"github.com/groovili/gogtrends"
+)
+
+// reports problems with the config file shared by every site, like sections which
+// aren't the top-level options, front matter defaults or site profiles (eg. a typo'd '[sites.foo]')
+func (config *ConfigData) validateFile() []error {
+	base := config
+	if config.base != nil {
+		base = config.base
+	}
+
+	if base.file == nil {
+		return nil
+	}
+
+	// problems with the top-level options are otherwise reported by the base config's Validate
+	var errs []error
+	if base != config {
+		errs = append(errs, base.errors...)
+	}
+
+	for _, name := range base.file.SectionStrings() {
+		site := strings.TrimPrefix(name, SITE_SECTION_PREFIX)
+		site = strings.TrimSuffix(site, "."+FRONTMATTER_SECTION)
+
+		switch {
+		case name == ini.DefaultSection || name == FRONTMATTER_SECTION:
+		case site != name && site != "" && !strings.Contains(site, "."):
+		default:
+			errs = append(errs, fmt.Errorf("Unknown section [%s]", name))
+		}
+	}
+
+	return errs
+}
+
+// makes sure the directory (or the closest parent that exists) is writable
+func checkWritable(dir string) error {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("'%s' is not a directory", dir)
+			}
+			break
+		}
+
+		if !os.IsNotExist(err) || path.Dir(dir) == dir {
+			return err
+		}
+		dir = path.Dir(dir)
+	}
+
+	f, err := os.CreateTemp(dir, ".copywriter-*")
+	if err != nil {
+		return fmt.Errorf("'%s' is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// returns every problem with the config, so they can be fixed before any paid calls are made
+func (config *ConfigData) Validate() []error {
+	errs := append([]error{}, config.errors...)
+
+	switch config.TopicType {
+	case TOPIC_TYPE_TRENDS, TOPIC_TYPE_NEWS:
+		if _, ok := gogtrends.TrendsCategories()[config.TrendingCategory]; !ok {
+			errs = append(errs, fmt.Errorf("Invalid trend category '%s'", config.TrendingCategory))
+		}
+	case TOPIC_TYPE_QUEUE:
+		if config.QueueFile == "" {
+			errs = append(errs, fmt.Errorf("Topic type '%s' requires a queue file", TOPIC_TYPE_QUEUE))
+		} else if _, err := os.Stat(config.QueueFile); err != nil {
+			errs = append(errs, fmt.Errorf("Bad queue file: %v", err))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("Invalid topic type '%s'", config.TopicType))
+	}
+
+	switch config.ImageProvider {
+	case IMAGE_PROVIDER_AUTO, IMAGE_PROVIDER_SCRAPER:
+	case IMAGE_PROVIDER_REPLICATE:
+		if util.GetEnv("REPLICATE_API_KEY", "") == "" && !util.IsDryRun() {
+			errs = append(errs, fmt.Errorf("Image provider '%s' requires REPLICATE_API_KEY to be set", config.ImageProvider))
+		}
+	case IMAGE_PROVIDER_LIBRARY:
+		if config.LibraryDir == "" {
+			errs = append(errs, fmt.Errorf("Image provider '%s' requires a library directory", config.ImageProvider))
+		} else if info, err := os.Stat(config.LibraryDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("Bad library directory '%s'", config.LibraryDir))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("Invalid image provider '%s'", config.ImageProvider))
+	}
+
+	if config.LibraryReuseDays < 0 {
+		errs = append(errs, fmt.Errorf("Library reuse days can't be negative, got %d", config.LibraryReuseDays))
+	}
+
+	if config.DedupeDistance < 0 || config.DedupeDistance > 64 {
+		errs = append(errs, fmt.Errorf("Dedupe distance must be between 0 and 64 bits, got %d", config.DedupeDistance))
+	}
+
+	if config.DedupeDays < 0 {
+		errs = append(errs, fmt.Errorf("Dedupe days can't be negative, got %d", config.DedupeDays))
+	}
+
+	if config.Captioner != "" {
+		if model, _ := splitModel(config.Captioner); !strings.Contains(model, "/") {
+			errs = append(errs, fmt.Errorf("Invalid captioner '%s', expected 'owner/name' or 'owner/name:version'", config.Captioner))
+		}
+
+		if util.GetEnv("REPLICATE_API_KEY", "") == "" && !util.IsDryRun() {
+			errs = append(errs, fmt.Errorf("The captioner requires REPLICATE_API_KEY to be set"))
+		}
+	}
+
+	if config.FallbackImage != "" {
+		if u, err := url.Parse(config.FallbackImage); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("Invalid fallback image url '%s'", config.FallbackImage))
+		} else if config.LicensedImages {
+			errs = append(errs, fmt.Errorf("The fallback image's license isn't known, it can't be used with licensedImages"))
+		}
+	}
+
+	if config.WebhookURL != "" {
+		if u, err := url.Parse(config.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("Invalid webhook url '%s'", config.WebhookURL))
+		}
+
+		if _, _, err := net.SplitHostPort(config.WebhookListen); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid webhook listen address '%s': %v", config.WebhookListen, err))
+		}
+	}
+
+	if config.ImageWorkers < 1 {
+		errs = append(errs, fmt.Errorf("Image workers must be at least 1, got %d", config.ImageWorkers))
+	}
+
+	if config.ReplicateRate < 0 || config.ScraperRate < 0 {
+		errs = append(errs, fmt.Errorf("Image rate limits can't be negative"))
+	}
+
+	switch config.StructuredData {
+	case STRUCTURED_DATA_OFF, STRUCTURED_DATA_FRONTMATTER, STRUCTURED_DATA_FILE:
+	default:
+		errs = append(errs, fmt.Errorf("Invalid structured data output '%s'", config.StructuredData))
+	}
+
+	if !isSchemaType(config.SchemaType) {
+		errs = append(errs, fmt.Errorf("Invalid schema type '%s', expected one of %s", config.SchemaType, strings.Join(SCHEMA_TYPES, ", ")))
+	}
+
+	if config.BaseURL != "" {
+		if u, err := url.Parse(config.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("Invalid base url '%s'", config.BaseURL))
+		}
+	}
+
+	if config.ThumbnailAspect != "" {
+		if _, _, err := thumbnail.ParseAspect(config.ThumbnailAspect); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	switch config.ThumbnailCrop {
+	case thumbnail.CROP_CENTER, thumbnail.CROP_ENTROPY:
+	default:
+		errs = append(errs, fmt.Errorf("Invalid thumbnail crop '%s'", config.ThumbnailCrop))
+	}
+
+	if config.ThumbnailWidth < 0 {
+		errs = append(errs, fmt.Errorf("Thumbnail width can't be negative, got %d", config.ThumbnailWidth))
+	}
+
+	if config.Upscaler != "" {
+		if config.ThumbnailWidth == 0 {
+			errs = append(errs, fmt.Errorf("The upscaler needs a thumbnail width to upscale to"))
+		}
+
+		if model, _ := splitModel(config.Upscaler); !strings.Contains(model, "/") {
+			errs = append(errs, fmt.Errorf("Invalid upscaler '%s', expected 'owner/name' or 'owner/name:version'", config.Upscaler))
+		}
+
+		if util.GetEnv("REPLICATE_API_KEY", "") == "" && !util.IsDryRun() {
+			errs = append(errs, fmt.Errorf("The upscaler requires REPLICATE_API_KEY to be set"))
+		}
+	}
+
+	// api keys aren't needed if nothing is actually being paid for
+	if util.GetEnv("OPENAI_API_KEY", "") == "" && !util.IsDryRun() {
+		errs = append(errs, fmt.Errorf("OPENAI_API_KEY is not set"))
+	}
+
+	// only the directory posts will actually be written to matters
+	if config.postDirOverride != "" {
+		if err := checkWritable(config.postDirOverride); err != nil {
+			errs = append(errs, fmt.Errorf("Bad output directory: %v", err))
+		}
+	} else {
+		if err := checkWritable(config.OutDir); err != nil {
+			errs = append(errs, fmt.Errorf("Bad output directory: %v", err))
+		}
+
+		if config.StagingDir != "" {
+			if err := checkWritable(config.StagingDir); err != nil {
+				errs = append(errs, fmt.Errorf("Bad staging directory: %v", err))
+			}
+		}
+	}
+
+	if config.PromptDir != "" {
+		if info, err := os.Stat(config.PromptDir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Errorf("Bad prompts directory '%s'", config.PromptDir))
+		}
+	}
+
+	prompts.Configure(config.PromptDir, config.Locale)
+	if err := prompts.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	// prefix the site so problems are easy to find with -all-sites
+	if config.Site != "" {
+		for i, err := range errs {
+			errs[i] = fmt.Errorf("site '%s': %v", config.Site, err)
+		}
+	}
+
+	return errs
+}
+
+// validates the config file and each config, returning every problem found
+func validateConfigs(configs []*ConfigData) []error {
+	if len(configs) == 0 {
+		return nil
+	}
+
+	errs := configs[0].validateFile()
+	for _, config := range configs {
+		errs = append(errs, config.Validate()...)
+	}
+
+	return errs
+}
+
+// reports every problem with the configs and exits if there were any
+func requireValidConfigs(configs []*ConfigData) {
+	errs := validateConfigs(configs)
+	for _, err := range errs {
+		util.Warning("%v", err)
+	}
+
+	if len(errs) > 0 {
+		util.Fail("Found %d problem(s) with the configuration", len(errs))
+	}
+}
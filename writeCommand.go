@@ -2,25 +2,119 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"path"
 	"strings"
 
+	"git.openpunk.com/CPunch/copywriter/prompts"
 	"git.openpunk.com/CPunch/copywriter/util"
 	"github.com/google/subcommands"
 )
 
+// returned when the post is thrown away during review, which isn't a failure
+var errPostDiscarded = errors.New("Post was discarded")
+
 type WriteCommand struct {
-	OutDir string
+	OutDir     string
+	Brief      string
+	AllSites   bool
+	DryRun     bool
+	Review     bool
+	PromptsOut string
 }
 
 func (*WriteCommand) Name() string     { return "write" }
 func (*WriteCommand) Synopsis() string { return "Write a post" }
 func (w *WriteCommand) SetFlags(f *flag.FlagSet) {
-	f.StringVar(&w.OutDir, "o", ".", "output directory")
+	f.StringVar(&w.OutDir, "o", "", "output directory, takes priority over the configured 'staging' and 'out' directories")
+	f.StringVar(&w.Brief, "brief", "", "editorial brief (yaml) steering the post")
+	f.BoolVar(&w.AllSites, "all-sites", false, "write one post for every site profile in the config")
+	f.BoolVar(&w.Review, "review", false, "review (and fix up) the generated post before it's written")
+	f.BoolVar(&w.DryRun, "dry-run", false, "print the prompts and planned actions without making any paid calls or writing the post")
+	f.StringVar(&w.PromptsOut, "prompts-out", "", "with -dry-run, also save the prompts and front matter to this directory")
 }
 
 func (*WriteCommand) Usage() string {
-	return "write [-o outdir] <title>:\n\tWrite a post. If title is not provided, one will be generated based on previous post titles.\n"
+	return "write [-o outdir] [-brief brief.yaml] [-all-sites] [-review] [-dry-run [-prompts-out dir]] <title>:\n\tWrite a post. If title is not provided, the brief's title is used or one will be generated based on the selected topic type.\n"
+}
+
+// writes a single post using the given (site) config
+func (w *WriteCommand) writePost(ctx context.Context, config *ConfigData, title string) error {
+	if config.Site != "" {
+		util.Info("Writing post for site '%s'...", config.Site)
+	}
+	prompts.Configure(config.PromptDir, config.Locale)
+	// the prompts of the previous site aren't this post's
+	util.ClearRecordedPrompts()
+
+	// create the blog writer, set the title and output directory
+	bw := NewBlogWriter(config)
+	bw.ctx = ctx
+	// library images of a post which failed can be used by the next one
+	defer bw.releaseLibraryImages("")
+	if w.Brief != "" {
+		brief, err := LoadBrief(w.Brief)
+		if err != nil {
+			return fmt.Errorf("Failed to load brief: %v", err)
+		}
+		bw.setBrief(brief)
+	}
+
+	if err := bw.setTitle(title); err != nil {
+		return fmt.Errorf("Failed to set title: %v", err)
+	}
+
+	if err := bw.setupOutDir(config.postDir()); err != nil {
+		return err
+	}
+
+	// generate & write the post
+	if err := bw.Generate(); err != nil {
+		return fmt.Errorf("Failed to generate post: %v", err)
+	}
+
+	if w.Review && !reviewPost(bw) {
+		bw.discard()
+		return errPostDiscarded
+	}
+
+	if err := bw.Save(); err != nil {
+		return fmt.Errorf("Failed to write post: %v", err)
+	}
+
+	if w.DryRun && w.PromptsOut != "" {
+		if err := w.saveDryRun(bw); err != nil {
+			return fmt.Errorf("Failed to save dry run: %v", err)
+		}
+	}
+	return nil
+}
+
+// saves the recorded prompts, planned output directory and front matter skeleton
+func (w *WriteCommand) saveDryRun(bw *BlogWriter) error {
+	dir := path.Join(w.PromptsOut, bw.config.Site)
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+
+	for i, prompt := range util.RecordedPrompts() {
+		kind := strings.Fields(prompt.Kind)[0]
+		fileName := path.Join(dir, fmt.Sprintf("%02d-%s.txt", i+1, kind))
+		if err := os.WriteFile(fileName, []byte(prompt.Kind+"\n---\n"+prompt.Prompt), 0644); err != nil {
+			return err
+		}
+	}
+
+	plan := fmt.Sprintf("title: %s\noutput: %s\n", bw.Title, bw.outDir)
+	if err := os.WriteFile(path.Join(dir, "plan.txt"), []byte(plan), 0644); err != nil {
+		return err
+	}
+
+	util.Info("Saved dry run to '%s'", dir)
+	return os.WriteFile(path.Join(dir, "frontmatter.yaml"), []byte(bw.genHeaders(util.GetTimeString())), 0644)
 }
 
 func (w *WriteCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interface{}) subcommands.ExitStatus {
@@ -34,17 +128,60 @@ func (w *WriteCommand) Execute(ctx context.Context, f *flag.FlagSet, _ ...interf
 
 	title = strings.TrimSpace(title)
 
-	// create the blog writer, set the title and output directory
-	bw := NewBlogWriter(config)
-	if err := bw.setTitle(title); err != nil {
-		util.Fail("Failed to set title: %v", err)
+	if w.DryRun {
+		util.EnableDryRun()
+	} else if w.PromptsOut != "" {
+		util.Fail("-prompts-out requires -dry-run")
+	}
+
+	if !w.AllSites {
+		config.postDirOverride = w.OutDir
+		requireValidConfigs([]*ConfigData{config})
+		if err := w.writePost(ctx, config, title); errors.Is(err, errPostDiscarded) {
+			util.Info("%v", err)
+			return subcommands.ExitSuccess
+		} else if err != nil {
+			util.Fail("%v", err)
+		}
+
+		util.Success("Done!")
+		return subcommands.ExitSuccess
+	}
+
+	sites := config.Sites()
+	if len(sites) == 0 {
+		util.Fail("No site profiles found in the config file")
+	}
+
+	// validate every site before spending anything
+	var configs []*ConfigData
+	for _, site := range sites {
+		siteConfig, err := config.ForSite(site)
+		if err != nil {
+			util.Fail("%v", err)
+		}
+		siteConfig.postDirOverride = w.OutDir
+		configs = append(configs, siteConfig)
 	}
+	requireValidConfigs(configs)
 
-	bw.setupOutDir(w.OutDir)
+	// keep going if a site fails, the other sites still deserve their posts
+	var failed []string
+	for _, siteConfig := range configs {
+		if ctx.Err() != nil {
+			util.Fail("Interrupted, skipping the remaining sites")
+		}
+
+		if err := w.writePost(ctx, siteConfig, title); errors.Is(err, errPostDiscarded) {
+			util.Info("Site '%s' skipped: %v", siteConfig.Site, err)
+		} else if err != nil {
+			util.Warning("Site '%s' failed: %v", siteConfig.Site, err)
+			failed = append(failed, siteConfig.Site)
+		}
+	}
 
-	// write the post
-	if err := bw.WritePost(); err != nil {
-		util.Fail("Failed to generate post: %v", err)
+	if len(failed) > 0 {
+		util.Fail("Failed to write posts for sites: %s", strings.Join(failed, ", "))
 	}
 
 	util.Success("Done!")
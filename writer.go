@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 	"unicode"
 
 	"git.openpunk.com/CPunch/copywriter/imagescraper"
+	"git.openpunk.com/CPunch/copywriter/prompts"
 	"git.openpunk.com/CPunch/copywriter/replicate"
+	"git.openpunk.com/CPunch/copywriter/topicqueue"
 	"git.openpunk.com/CPunch/copywriter/trendscraper"
 	"git.openpunk.com/CPunch/copywriter/util"
 )
@@ -19,18 +26,49 @@ const (
 	MAX_RETRY = 5
 )
 
+var (
+	// rate limiters are per provider and shared by every post, so writing every site
+	// at once doesn't go over the limit
+	imageLimiters   = make(map[string]*util.RateLimiter)
+	imageLimitersMu sync.Mutex
+
+	// replicate webhook receivers by listen address, nil if it failed to start
+	webhookReceivers   = make(map[string]*replicate.WebhookReceiver)
+	webhookReceiversMu sync.Mutex
+)
+
+// an image marked in the markdown, waiting to be generated
+type imageJob struct {
+	Line   int // line of the marker
+	Prompt string
+	File   string
+}
+
 type BlogWriter struct {
-	config     *ConfigData
-	outDir     string
-	imageCount int
-	maxImages  int
-	TitleCtx   string
-	ArticleCtx string
-	Title      string
-	Content    string // markdown with injected images
-	Tags       string
-	Author     string
-	Thumbnail  string
+	config         *ConfigData
+	ctx            context.Context // canceling it stops in-flight image generation
+	started        time.Time       // library images used since aren't reused in the post
+	outDir         string
+	imageCount     int
+	maxImages      int
+	TitleCtx       string
+	ArticleCtx     string
+	Title          string
+	Content        string // markdown with injected images
+	Tags           string
+	Author         string
+	Thumbnail      string
+	ThumbnailQuery string
+	ThumbnailInfo  *ThumbnailInfo     // nil unless the thumbnail was processed
+	SEO            *SEO               // nil until generated
+	Credits        map[string]*Credit // attribution of images we didn't generate, by file
+	creditsMu      sync.Mutex
+	imageHashes    map[string]uint64 // perceptual hashes of images saved since the post was last written, by file
+	imageHashesMu  sync.Mutex
+	libraryPicks   []libraryPick // library images copied since the post was last written
+	libraryPicksMu sync.Mutex
+	queueEntry     *topicqueue.Entry // set if the title was generated from a queue entry
+	brief          *Brief            // optional editorial brief steering the post
 }
 
 func genBlogFilePath(title string) string {
@@ -51,58 +89,259 @@ func genBlogFilePath(title string) string {
 
 func NewBlogWriter(config *ConfigData) *BlogWriter {
 	return &BlogWriter{
-		config:     config,
-		imageCount: 0,
+		config:      config,
+		ctx:         context.Background(),
+		started:     time.Now(),
+		Credits:     make(map[string]*Credit),
+		imageHashes: make(map[string]uint64),
+		imageCount:  0,
 	}
 }
 
 // builds the output directory for the blog writer
-func (bw *BlogWriter) setupOutDir(outDir string) {
+func (bw *BlogWriter) setupOutDir(outDir string) error {
 	dirPath := path.Join(outDir, genBlogFilePath(bw.Title))
+	if util.IsDryRun() {
+		util.Info("Post would be written to '%s'", dirPath)
+		bw.outDir = dirPath
+		return nil
+	}
+
 	if err := os.MkdirAll(dirPath, 0777); err != nil {
-		util.Fail("Failed to create directory '%s': %v", dirPath, err)
+		return fmt.Errorf("Failed to create directory '%s': %v", dirPath, err)
 	}
 	bw.outDir = dirPath
+	return nil
 }
 
-func (bw *BlogWriter) getNextFile() (fileName, filePath string) {
+// moves the output directory to a new name next to it
+func (bw *BlogWriter) moveDir(name string) error {
+	newDir := path.Join(path.Dir(bw.outDir), name)
+	if newDir != bw.outDir && !util.IsDryRun() {
+		if _, err := os.Stat(newDir); err == nil {
+			return fmt.Errorf("'%s' already exists", newDir)
+		}
+
+		if err := os.Rename(bw.outDir, newDir); err != nil {
+			return err
+		}
+
+		bw.renameImageHashes(path.Base(bw.outDir), path.Base(newDir))
+	}
+
+	bw.outDir = newDir
+	return nil
+}
+
+func (bw *BlogWriter) getNextFile() string {
 	bw.imageCount++
-	fileName = fmt.Sprintf("file_%d.jpg", bw.imageCount)
-	filePath = path.Join(bw.outDir, fileName)
-	return
+	return fmt.Sprintf("file_%d.jpg", bw.imageCount)
 }
 
 // generate or scrapes the web for the query.
 // returns the filename of the downloaded image
 // in the outDir
 func (bw *BlogWriter) genImage(query string) (string, error) {
+	fileName := bw.getNextFile()
+	return fileName, bw.genImageAs(query, fileName)
+}
+
+func imageLimiter(provider string, perMinute int) *util.RateLimiter {
+	imageLimitersMu.Lock()
+	defer imageLimitersMu.Unlock()
+
+	if _, ok := imageLimiters[provider]; !ok {
+		imageLimiters[provider] = util.NewRateLimiter(perMinute)
+	}
+	return imageLimiters[provider]
+}
+
+// starts the webhook receiver for the config's listen address, once. returns nil if webhooks
+// aren't configured or the receiver couldn't be started, in which case predictions are polled.
+// the receiver stops once ctx is done
+func webhookReceiver(ctx context.Context, config *ConfigData, rc *replicate.ReplicateClient) *replicate.WebhookReceiver {
+	if config.WebhookURL == "" {
+		return nil
+	}
+
+	webhookReceiversMu.Lock()
+	defer webhookReceiversMu.Unlock()
+
+	if receiver, ok := webhookReceivers[config.WebhookListen]; ok {
+		return receiver
+	}
+	webhookReceivers[config.WebhookListen] = nil
+
+	// the signing secret can be set to save a request
+	secret := util.GetEnv("REPLICATE_WEBHOOK_SECRET", "")
+	if secret == "" {
+		var err error
+		if secret, err = rc.GetWebhookSecret(ctx); err != nil {
+			util.Warning("Polling replicate instead of using webhooks: %v", err)
+			return nil
+		}
+	}
+
+	listener, err := net.Listen("tcp", config.WebhookListen)
+	if err != nil {
+		util.Warning("Polling replicate instead of using webhooks: %v", err)
+		return nil
+	}
+
+	receiver := replicate.NewWebhookReceiver(secret)
+	go func() {
+		if err := receiver.Serve(ctx, listener); err != nil {
+			util.Warning("Replicate webhook receiver stopped: %v", err)
+		}
+	}()
+	util.Info("Listening for replicate webhooks on '%s'...", config.WebhookListen)
+
+	webhookReceivers[config.WebhookListen] = receiver
+	return receiver
+}
+
+// a replicate client, using the webhook receiver if one is configured
+func replicateClient(ctx context.Context, config *ConfigData) *replicate.ReplicateClient {
+	rc := replicate.NewClient(util.GetEnv("REPLICATE_API_KEY", ""))
+	if receiver := webhookReceiver(ctx, config, rc); receiver != nil {
+		rc.WebhookURL = config.WebhookURL
+		rc.Webhook = receiver
+	}
+	return rc
+}
+
+// runs a replicate model ("owner/name" or "owner/name:version"), counting towards the
+// replicate rate limit
+func runModel(ctx context.Context, config *ConfigData, model string, input interface{}) (*replicate.Prediction, *replicate.ReplicateClient, error) {
+	imageLimiter(IMAGE_PROVIDER_REPLICATE, config.ReplicateRate).Wait()
+	rc := replicateClient(ctx, config)
+
+	req := replicate.PredictionRequest{Input: input}
+	if name, version := splitModel(model); version != "" {
+		req.Version = version
+	} else {
+		req.Model = name
+	}
+
+	prediction, err := rc.Run(ctx, req, nil)
+	return prediction, rc, err
+}
+
+// same as genImage, but (over)writes the given file in the outDir. safe to call concurrently
+func (bw *BlogWriter) genImageAs(query, fileName string) error {
+	// the style is meant for generated images, it'd only add noise to library matches
+	prompt := query
 	if bw.config.ImageStylePrompt != "" {
 		query = query + " " + strings.TrimSpace(bw.config.ImageStylePrompt)
 	}
 
 	util.Info("Generating image for query '%s'...", query)
 
-	fileName, filePath := bw.getNextFile()
+	// by default, check if REPLICATE_API_KEY is in our environment, if it's not we'll fallback to our image scraper
+	token := util.GetEnv("REPLICATE_API_KEY", "")
+	provider := bw.config.ImageProvider
+	if provider == IMAGE_PROVIDER_AUTO {
+		provider = IMAGE_PROVIDER_SCRAPER
+		if token != "" {
+			provider = IMAGE_PROVIDER_REPLICATE
+		}
+	}
+
+	if util.IsDryRun() {
+		util.RecordPrompt("image ("+provider+")", query)
+		return nil
+	}
+
+	// the old credit doesn't apply to whatever replaces the image
+	bw.setCredit(fileName, nil)
+
+	// scraped images which turned out to be duplicates, so they aren't picked again
+	var exclude []string
+	for attempt := 0; ; attempt++ {
+		source, credit, err := bw.fetchImage(provider, prompt, query, fileName, exclude)
+		if err != nil && attempt == 0 {
+			return err
+		} else if err != nil {
+			util.Warning("Failed to replace '%s', keeping the duplicate: %v", fileName, err)
+			return nil
+		}
+		bw.setCredit(fileName, credit)
+
+		duplicate, err := bw.checkDuplicate(fileName)
+		if err != nil {
+			return err
+		} else if duplicate == nil {
+			return nil
+		}
+
+		if attempt == MAX_DUPLICATE_RETRIES {
+			util.Warning("'%s' still looks like '%s' in '%s', keeping it anyway", fileName, duplicate.File, duplicate.Post)
+			return nil
+		}
+
+		util.Warning("'%s' looks like '%s' in '%s', replacing it...", fileName, duplicate.File, duplicate.Post)
+		if source != "" {
+			exclude = append(exclude, source)
+		}
+	}
+}
+
+// saves an image from the provider to the given file in the outDir, returning the url of
+// scraped images and the image's credit, if any. scraped urls in exclude aren't used
+func (bw *BlogWriter) fetchImage(provider, prompt, query, fileName string, exclude []string) (string, *Credit, error) {
+	filePath := path.Join(bw.outDir, fileName)
 	header := make(http.Header)
 	var url string
 
-	// check if REPLICATE_API_KEY is in our environment, if it's not we'll fallback to our image scraper
-	if token := util.GetEnv("REPLICATE_API_KEY", ""); token != "" {
+	if provider == IMAGE_PROVIDER_LIBRARY {
+		found, credit, err := bw.copyFromLibrary(prompt, filePath)
+		if err != nil || found {
+			return "", credit, err
+		}
+
+		util.Warning("Nothing in the image library matches '%s', using the image scraper instead", prompt)
+		provider = IMAGE_PROVIDER_SCRAPER
+	}
+
+	if provider == IMAGE_PROVIDER_REPLICATE {
+		if util.GetEnv("REPLICATE_API_KEY", "") == "" {
+			return "", nil, fmt.Errorf("Image provider '%s' requires REPLICATE_API_KEY to be set", provider)
+		}
+		imageLimiter(provider, bw.config.ReplicateRate).Wait()
 		util.Info("Using replicate.ai to generate image...")
 
-		rc := replicate.NewClient(token)
+		rc := replicateClient(bw.ctx, bw.config)
 		header = rc.Header
 
 		var err error
-		url, err = rc.MakePrediction(query)
+		url, err = rc.MakePredictionContext(bw.ctx, query)
 		if err != nil {
-			return "", fmt.Errorf("Failed to generate image: %v", err)
+			return "", nil, fmt.Errorf("Failed to generate image: %v", err)
 		}
 	} else {
+		imageLimiter(provider, bw.config.ScraperRate).Wait()
 		util.Info("Using image scraper to grab an image...")
 
-		url = imagescraper.GetImageUrl(query)
-		header.Set("User-Agent", util.USER_AGENT)
+		img, err := imagescraper.GetImage(bw.ctx, query, bw.config.LicensedImages, exclude...)
+		if err != nil && bw.ctx.Err() == nil && bw.usableFallback(exclude) {
+			util.Warning("%v, using the fallback image", err)
+			img, err = &imagescraper.Image{URL: bw.config.FallbackImage, SourcePage: bw.config.FallbackImage, License: imagescraper.License{Name: FALLBACK_LICENSE}}, nil
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to find image: %v", err)
+		}
+
+		// most of the image was already fetched while validating it
+		util.Info("Downloading %s to '%s'...", img.URL, filePath)
+		data, err := img.Download()
+		if err != nil {
+			return "", nil, fmt.Errorf("Failed to download image: %v", err)
+		}
+		if err := os.WriteFile(filePath, data, 0644); err != nil {
+			return "", nil, fmt.Errorf("Failed to save image: %v", err)
+		}
+
+		return img.URL, creditFromScraper(fileName, img), nil
 	}
 
 	// download image
@@ -111,44 +350,104 @@ func (bw *BlogWriter) genImage(query string) (string, error) {
 		FilePath: filePath,
 		Header:   header,
 	}); err != nil {
-		return "", fmt.Errorf("Failed to download image: %v", err)
+		return "", nil, fmt.Errorf("Failed to download image: %v", err)
 	}
 
-	return fileName, nil
+	return "", nil, nil
+}
+
+// true if the configured fallback image can stand in for a scraped image. like any
+// other image, it isn't used again once it turned out to be a duplicate
+func (bw *BlogWriter) usableFallback(exclude []string) bool {
+	if bw.config.FallbackImage == "" || bw.config.LicensedImages {
+		return false
+	}
+
+	for _, url := range exclude {
+		if url == bw.config.FallbackImage {
+			return false
+		}
+	}
+	return true
 }
 
-func (bw *BlogWriter) genImageAboutMeta(prompt string) (img string, query string, err error) {
-	query, err = util.GenerateResponse(util.ResponseOptions{
+// writes an image prompt which fits the text
+func (bw *BlogWriter) genImageMetaQuery(text string) (string, error) {
+	prompt, err := prompts.Render(prompts.IMAGE_META, prompts.Vars{Title: bw.Title, Content: text})
+	if err != nil {
+		return "", err
+	}
+
+	query, err := util.GenerateResponse(util.ResponseOptions{
+		Ctx:       bw.ctx,
 		MaxTokens: 30,
-		Prompt:    fmt.Sprintf("%s\n---\nWrite a short one sentence prompt for an image that fits the above text: Image of ", prompt),
+		Prompt:    prompt,
 		UseGPT4:   true,
 		Clean:     true,
 	})
 	if err != nil {
-		return "", "", fmt.Errorf("Failed to generate image: %v", err)
+		return "", fmt.Errorf("Failed to generate image: %v", err)
+	}
+
+	return query, nil
+}
+
+func (bw *BlogWriter) genImageAboutMeta(text string) (img string, query string, err error) {
+	if query, err = bw.genImageMetaQuery(text); err != nil {
+		return "", "", err
 	}
 
 	img, err = bw.genImage(query)
 	return
 }
 
+// generates the images, at most config.ImageWorkers at once. every image is
+// attempted, the first error is returned
+func (bw *BlogWriter) genImages(jobs []imageJob) error {
+	workers := bw.config.ImageWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, len(jobs))
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(jobs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				errs[i] = bw.genImageAs(jobs[i].Prompt, jobs[i].File)
+			}
+		}()
+	}
+
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (bw *BlogWriter) populateImages(content string) (string, error) {
 	util.Info("Populating images...")
 	lines := strings.Split(content, "\n")
 
-	// look for '![]('
+	// look for '![](', files are numbered in the order the images appear
+	var jobs []imageJob
 	for i := 0; i < len(lines); i++ {
 		if strings.Contains(lines[i], "![](") {
 			imgPrompt := strings.ReplaceAll(lines[i], "![](", "")
 			imgPrompt = strings.ReplaceAll(imgPrompt, ")", "")
-
-			// inject image
-			imgURL, err := bw.genImage(imgPrompt)
-			if err != nil {
-				return "", fmt.Errorf("Failed to generate image: %v", err)
-			}
-
-			lines[i] = fmt.Sprintf("\n![](%s)", imgURL)
+			imgPrompt = strings.TrimSpace(strings.NewReplacer("[", "", "]", "").Replace(imgPrompt))
+			jobs = append(jobs, imageJob{Line: i, Prompt: imgPrompt, File: bw.getNextFile()})
 		}
 
 		// gpt sometimes writes this at the end of the content, so just remove everything after
@@ -158,15 +457,53 @@ func (bw *BlogWriter) populateImages(content string) (string, error) {
 		}
 	}
 
+	if err := bw.genImages(jobs); err != nil {
+		return "", fmt.Errorf("Failed to generate image: %v", err)
+	}
+
+	// inject images, the prompt is kept as the alt text so the image can be regenerated later
+	for _, job := range jobs {
+		lines[job.Line] = fmt.Sprintf("\n![%s](%s)", job.Prompt, job.File)
+	}
+
 	return strings.Join(lines, "\n"), nil
 }
 
+// template variables shared by all of the prompts for this post
+func (bw *BlogWriter) promptVars() prompts.Vars {
+	vars := prompts.Vars{
+		Title:        bw.Title,
+		CustomPrompt: bw.config.CustomPrompt,
+		TitleCtx:     bw.TitleCtx,
+		ArticleCtx:   bw.ArticleCtx,
+		WordCount:    DEFAULT_WORD_COUNT,
+		Locale:       bw.config.Locale,
+	}
+
+	if bw.brief != nil {
+		vars.BriefCtx = bw.brief.articleCtx()
+		vars.WordCount = bw.brief.WordCount
+		vars.Keywords = bw.brief.Keywords
+	}
+
+	return vars
+}
+
 func (bw *BlogWriter) genBlogTags() (string, error) {
 	util.Info("Generating tags...")
+	vars := bw.promptVars()
+	vars.Content = bw.Content
+	prompt, err := prompts.Render(prompts.TAGS, vars)
+	if err != nil {
+		return "", err
+	}
+
 	for i := 0; i < MAX_RETRY; i++ { // just in case gpt is a DUMBASS; i don't wanna burn a million dollars
 		tagString, err := util.GenerateResponse(util.ResponseOptions{
+			Ctx:       bw.ctx,
 			MaxTokens: 50,
-			Prompt:    fmt.Sprintf("%s\n\nTags as a json array with only 1 word each, max 5:\n", bw.Content),
+			Prompt:    prompt,
+			Stub:      `["dry", "run"]`,
 			UseGPT4:   false,
 		})
 		if err != nil {
@@ -190,13 +527,15 @@ func (bw *BlogWriter) genBlogTags() (string, error) {
 
 func (bw *BlogWriter) genBlogTitle() (string, error) {
 	util.Info("Generating blog title...")
+	prompt, err := prompts.Render(prompts.TITLE, bw.promptVars())
+	if err != nil {
+		return "", err
+	}
 
 	title, err := util.GenerateResponse(util.ResponseOptions{
-		MaxTokens: 40,
-		Prompt: fmt.Sprintf(
-			"%s\n%s\n---\nWrite a short, simple and SEO optimized title for long-tail searches of an article which relates to anything above: ",
-			bw.config.CustomPrompt, bw.TitleCtx,
-		),
+		Ctx:                   bw.ctx,
+		MaxTokens:             40,
+		Prompt:                prompt,
 		UseGPT4:               false,
 		Clean:                 true,
 		CleanKeepPunctuations: true,
@@ -219,54 +558,156 @@ func (bw *BlogWriter) genBlogContent() (string, error) {
 		return "", fmt.Errorf("Failed to generate thumbnail: %v", err)
 	}
 	bw.Thumbnail = thumb
+	bw.ThumbnailQuery = thumbnailQuery
+	if err := bw.processThumbnail(); err != nil {
+		return "", err
+	}
+
+	vars := bw.promptVars()
+	vars.ThumbnailQuery = thumbnailQuery
+	prompt, err := prompts.Render(prompts.ARTICLE, vars)
+	if err != nil {
+		return "", err
+	}
 
 	util.Info("Generating blog post contents...")
 	markdown, err := util.GenerateResponse(util.ResponseOptions{
+		Ctx:       bw.ctx,
 		MaxTokens: 5000,
-		Prompt: fmt.Sprintf(
-			"%s\n%s\nWrite an interesting and informative 1000 word article that readers would find relevant written in markdown. Use '##' for section headings. Mark where you would insert an image using '![](<DESCRIPTION OF IMAGE>)'.\n---\n\n## %s\n\n![](%s)\n",
-			bw.config.CustomPrompt, bw.ArticleCtx, bw.Title, thumbnailQuery,
-		),
-		UseGPT4: true,
-		Clean:   false,
+		Prompt:    prompt,
+		UseGPT4:   true,
+		UseLong:   vars.WordCount > 2500, // 5000 tokens won't fit in the 8k context alongside a long prompt
+		Stub:      "## Dry run\n\nThe article would go here.\n\n![](an example image of the article)\n",
+		Clean:     false,
 	})
 	if err != nil {
 		return "", err
 	}
 
 	// inject images
-	return bw.populateImages(markdown)
+	markdown, err = bw.populateImages(markdown)
+	if err != nil {
+		return "", err
+	}
+
+	if bw.brief != nil {
+		markdown = bw.brief.enforce(markdown)
+	}
+	return markdown, nil
 }
 
-func (bw *BlogWriter) genHeaders() string {
+func (bw *BlogWriter) genHeaders(date string) string {
+	// front matter defaults from the config, generated fields take priority
+	var extra string
+	keys := make([]string, 0, len(bw.config.FrontMatter))
+	for key := range bw.config.FrontMatter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch key {
+		case "title", "author", "date", "draft", "tags", "image", "description", "focusKeyword", "slug", "og", "twitter", "thumbnail", "credits", JSONLD_PARAM:
+			util.Warning("Ignoring front matter default '%s', it's generated", key)
+		case "toc":
+			if bw.config.TOC {
+				util.Warning("Ignoring front matter default 'toc', the 'toc' option is set")
+				continue
+			}
+			extra += fmt.Sprintf("%s: %s\n", key, bw.config.FrontMatter[key])
+		default:
+			extra += fmt.Sprintf("%s: %s\n", key, bw.config.FrontMatter[key])
+		}
+	}
+
+	seo := ""
+	if bw.SEO != nil {
+		seo = bw.SEO.headers(bw.socialImage())
+	}
+	if bw.ThumbnailInfo != nil {
+		seo = bw.ThumbnailInfo.headers() + seo
+	}
+	seo += creditsHeaders(bw.credits())
+	if bw.config.TOC {
+		extra = "toc: true\n" + extra
+	}
+
 	return fmt.Sprintf(
-		"---\ntitle: \"%s\"\nauthor: \"%s\"\ndate: \"%s\"\ntags: %s\nimage: \"%s\"\n---\n",
-		bw.Title, bw.Author, util.GetTimeString(), bw.Tags, bw.Thumbnail,
+		"---\ntitle: \"%s\"\nauthor: \"%s\"\ndate: \"%s\"\ndraft: %t\ntags: %s\nimage: \"%s\"\n%s%s%s---\n",
+		bw.Title, bw.Author, date, bw.config.Draft, bw.Tags, bw.Thumbnail, seo, bw.structuredDataHeader(date), extra,
 	)
 }
 
 func (bw *BlogWriter) genTopicCtx() (err error) {
+	if bw.config.TopicType == TOPIC_TYPE_QUEUE {
+		util.Info("Reading next entry from queue '%s'...", bw.config.QueueFile)
+		bw.queueEntry, err = topicqueue.NextEntry(bw.config.QueueFile)
+		if err != nil {
+			return
+		}
+
+		// entries can also point to a brief, relative to the queue file
+		if ext := path.Ext(bw.queueEntry.Text); ext == ".yaml" || ext == ".yml" {
+			briefPath := bw.queueEntry.Text
+			if !path.IsAbs(briefPath) {
+				briefPath = path.Join(path.Dir(bw.config.QueueFile), briefPath)
+			}
+
+			if bw.brief, err = LoadBrief(briefPath); err != nil {
+				return
+			}
+			bw.TitleCtx = bw.brief.titleCtx()
+			return
+		}
+
+		bw.TitleCtx = fmt.Sprintf("The following are keywords readers are interested in:\n%s", bw.queueEntry.Text)
+		bw.ArticleCtx = fmt.Sprintf("The article should cover the following:\n%s\n", bw.queueEntry.Text)
+		return
+	}
+
 	if bw.config.TopicType == TOPIC_TYPE_NEWS {
-		bw.TitleCtx, bw.ArticleCtx, err = trendscraper.ScrapeRealtimeNews(bw.config.TrendingCategory)
+		bw.TitleCtx, bw.ArticleCtx, err = trendscraper.ScrapeRealtimeNews(bw.ctx, bw.config.TrendingCategory, bw.config.Locale)
 		return
 	}
 
-	bw.TitleCtx, bw.ArticleCtx, err = trendscraper.ScrapePopularTrends(bw.config.TrendingCategory)
+	bw.TitleCtx, bw.ArticleCtx, err = trendscraper.ScrapePopularTrends(bw.ctx, bw.config.TrendingCategory, bw.config.Locale)
 	return
 }
 
-// passing an empty string "" will generate the title using the selected topic type
+func (bw *BlogWriter) setBrief(brief *Brief) {
+	bw.brief = brief
+}
+
+// passing an empty string "" will generate the title using the brief's title, or
+// the selected topic type
 func (bw *BlogWriter) setTitle(title string) error {
+	var err error
+	if title == "" && bw.brief != nil {
+		title = bw.brief.Title
+		bw.TitleCtx = bw.brief.titleCtx()
+	}
+
 	if title == "" {
-		err := bw.genTopicCtx()
-		if err != nil {
+		if bw.brief == nil {
+			if err = bw.genTopicCtx(); err != nil {
+				return err
+			}
+		}
+
+		// the topic may have given us a brief with a title
+		if bw.brief != nil && bw.brief.Title != "" {
+			title = bw.brief.Title
+		} else if title, err = bw.genBlogTitle(); err != nil {
 			return err
 		}
+	}
 
-		title, err = bw.genBlogTitle()
+	if bw.brief != nil {
+		refs, err := bw.brief.scrapeReferences(bw.ctx)
 		if err != nil {
-			return err
+			return fmt.Errorf("Failed to scrape references: %v", err)
 		}
+		bw.ArticleCtx += refs
 	}
 
 	util.Info("Title: '%s'...", title)
@@ -274,7 +715,8 @@ func (bw *BlogWriter) setTitle(title string) error {
 	return nil
 }
 
-func (bw *BlogWriter) WritePost() error {
+// generates the post without writing index.md, see Save
+func (bw *BlogWriter) Generate() error {
 	var err error
 
 	bw.Content, err = bw.genBlogContent()
@@ -282,15 +724,45 @@ func (bw *BlogWriter) WritePost() error {
 		return fmt.Errorf("Failed to generate blog content: %v", err)
 	}
 
+	if err = bw.addModules(); err != nil {
+		return err
+	}
+	bw.renderCredits()
+
 	bw.Tags, err = bw.genBlogTags()
 	if err != nil {
 		return fmt.Errorf("Failed to generate blog tags: %v", err)
 	}
-	bw.Author = "Mason Coleman"
+	bw.Author = bw.config.Author
+
+	bw.SEO, err = bw.genSEO()
+	if err != nil {
+		return fmt.Errorf("Failed to generate SEO metadata: %v", err)
+	}
+
+	// the bundle is named after the optimized slug rather than the full title
+	if err := bw.moveDir(bw.SEO.Slug); err != nil {
+		util.Warning("Keeping '%s': %v", bw.outDir, err)
+	}
 
-	header := bw.genHeaders()
-	fullPost := fmt.Sprintf("%s\n%s", header, bw.Content)
 	util.Success("Generated post!")
+	return nil
+}
+
+// writes the generated post to index.md
+func (bw *BlogWriter) Save() error {
+	date := util.GetTimeString()
+	header := bw.genHeaders(date)
+	fullPost := fmt.Sprintf("%s\n%s", header, bw.Content)
+
+	if err := bw.writeStructuredData(date, ""); err != nil {
+		return fmt.Errorf("Failed to write structured data: %v", err)
+	}
+
+	if util.IsDryRun() {
+		util.Info("Front matter:\n%s", header)
+		return nil
+	}
 
 	// write hugo markdown file
 	outFile := path.Join(bw.outDir, "index.md")
@@ -298,9 +770,27 @@ func (bw *BlogWriter) WritePost() error {
 	if err := os.WriteFile(outFile, []byte(fullPost), 0644); err != nil {
 		return fmt.Errorf("Failed to write to file '%s': %v", outFile, err)
 	}
+
+	bw.recordImageHashes()
+	bw.keepLibraryImages()
+
+	// only mark the queue entry once the post actually exists
+	if bw.queueEntry != nil {
+		if err := topicqueue.MarkConsumed(bw.config.QueueFile, bw.queueEntry, path.Base(bw.outDir)); err != nil {
+			return fmt.Errorf("Failed to mark queue entry as consumed: %v", err)
+		}
+	}
 	return nil
 }
 
+func (bw *BlogWriter) WritePost() error {
+	if err := bw.Generate(); err != nil {
+		return err
+	}
+
+	return bw.Save()
+}
+
 // That mary was goin' around with an old flame. That burned me up,
 // because I knew he was just feeding her a line, but the guy really
 // spent his money like water! I think he was connected, so I left.